@@ -8,17 +8,98 @@ import (
 	"github.com/gookit/config/v2/yaml"
 )
 
+type Realm struct {
+	HTTPBase string `config:"http_base"`
+	WSBase   string `config:"ws_base"`
+	Path     string `config:"path"`
+	SiteBase string `config:"site_base"`
+	Host     string `config:"host"`
+	Origin   string `config:"origin"`
+	Language string `config:"language"`
+}
+
+type Account struct {
+	Cookie string `config:"cookie"`
+	// LiveLimit 该账号同时打开的直播搜索数，为0时使用Poe.LiveLimit
+	LiveLimit int `config:"live_limit"`
+	// Proxy 该账号使用的代理，为空时使用Poe.Proxy
+	Proxy string `config:"proxy"`
+}
+
+type NotifyTarget struct {
+	// Type 通知类型，目前支持wxwork，为空时为wxwork
+	Type string `config:"type"`
+	URL  string `config:"url"`
+	// Format 消息格式，text或者markdown，为空时为text
+	Format string `config:"format"`
+}
+
 type Config struct {
 	Port   int `config:"port"`
 	Notify struct {
 		Type string `config:"type"`
 		URL  string `config:"url"`
+		// Targets 命名的通知目标，记录通过notifiers选择一个或多个
+		Targets map[string]NotifyTarget `config:"targets"`
+		// Default 记录没有选择时使用的目标，为空时使用上面的Type、URL和Format
+		Default string `config:"default"`
+		Format  string `config:"format"`
+		// Template、MarkdownTemplate 全局的text/template模板，记录中的模板优先
+		Template         string `config:"template"`
+		MarkdownTemplate string `config:"markdown_template"`
 	} `config:"notify"`
 	DB struct {
 		Path string `config:"path"`
 	} `config:"db"`
+	// Price 通货估值，按顺序尝试URL和File，都没有配置时不估值
+	Price struct {
+		// URL poe.ninja格式的通货价格接口，{league}替换为赛季
+		// 比如 https://poe.ninja/api/data/currencyoverview?league={league}&type=Currency
+		URL string `config:"url"`
+		// File 本地价格文件，格式为 {"divine": 200}，值为折合的chaos数量
+		File string `config:"file"`
+		// 刷新间隔，单位：分钟
+		RefreshInterval int `config:"refresh_interval"`
+	} `config:"price"`
 	Poe struct {
 		RateLimit int `config:"rate_limit"`
+		// Proxy 全局代理，支持 http://、https://、socks5://
+		Proxy string `config:"proxy"`
+		// Realm 默认交易站点，可选tencent、pc、xbox、sony、poe2或者Realms中的自定义站点
+		Realm  string           `config:"realm"`
+		Realms map[string]Realm `config:"realms"`
+		// Accounts 账号池，记录没有指定cookie和账号时从中分配
+		Accounts map[string]Account `config:"accounts"`
+		// cookie检查间隔，单位：分钟
+		SessionCheckInterval int `config:"session_check_interval"`
+		// 直播搜索ping间隔，单位：秒
+		PingInterval int `config:"ping_interval"`
+		// 直播搜索超过该时间没有消息时重连，单位：分钟，为0时不检查
+		IdleTimeout int `config:"idle_timeout"`
+		// 每个账号同时打开的直播搜索数
+		LiveLimit int `config:"live_limit"`
+		// 直播搜索连接不足时的轮换间隔，单位：分钟
+		LiveRotateInterval int `config:"live_rotate_interval"`
+		// 批量获取详情的等待窗口，单位：毫秒
+		BatchWindow int `config:"batch_window"`
+		// 静态数据（联盟、词缀、物品、通货）刷新间隔，单位：小时
+		DataRefreshInterval int `config:"data_refresh_interval"`
+		// 轮询模式重新搜索的间隔，单位：秒
+		PollInterval int `config:"poll_interval"`
+		// auto模式直播搜索失败后轮询多久再尝试直播搜索，单位：分钟
+		LiveRetryInterval int `config:"live_retry_interval"`
+		// 已通知物品的去重时间，单位：小时
+		SeenTTL int `config:"seen_ttl"`
+		// 通货兑换轮询间隔，单位：秒
+		ExchangeInterval int `config:"exchange_interval"`
+		// RecordDir 录制交易请求和直播搜索消息的目录，每条记录每次运行一个文件，为空时不录制
+		RecordDir string `config:"record_dir"`
+		Reconnect struct {
+			// 单位：秒
+			MinDelay    int `config:"min_delay"`
+			MaxDelay    int `config:"max_delay"`
+			MaxAttempts int `config:"max_attempts"`
+		} `config:"reconnect"`
 	} `config:"poe"`
 }
 
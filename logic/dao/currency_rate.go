@@ -0,0 +1,46 @@
+package dao
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// CurrencyRates 赛季的通货价格，Rates的键为交易站点的通货标签，值为折合的chaos数量
+type CurrencyRates struct {
+	League    string             `json:"league"`
+	Source    string             `json:"source"`
+	Rates     map[string]float64 `json:"rates"`
+	UpdatedAt time.Time          `json:"updated_at"`
+}
+
+func (c *client) GetCurrencyRates(ctx context.Context, league string) (*CurrencyRates, error) {
+	rows, err := dbHandler.Query("SELECT league, source, data, updated_at FROM currency_rate WHERE league = ?", league)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, nil
+	}
+	rates := &CurrencyRates{}
+	var raw string
+	var updatedAt int64
+	if err := rows.Scan(&rates.League, &rates.Source, &raw, &updatedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(raw), &rates.Rates); err != nil {
+		return nil, err
+	}
+	rates.UpdatedAt = time.Unix(updatedAt, 0)
+	return rates, nil
+}
+
+func (c *client) SaveCurrencyRates(ctx context.Context, rates *CurrencyRates) error {
+	raw, err := json.Marshal(rates.Rates)
+	if err != nil {
+		return err
+	}
+	_, err = dbHandler.Exec("INSERT OR REPLACE INTO currency_rate (league, source, data, updated_at) VALUES (?, ?, ?, ?)", rates.League, rates.Source, string(raw), rates.UpdatedAt.Unix())
+	return err
+}
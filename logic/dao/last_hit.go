@@ -0,0 +1,39 @@
+package dao
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// Hit 记录最近一次通知的物品，Data为交易接口返回的物品JSON
+type Hit struct {
+	RecordID  int64           `json:"record_id"`
+	Data      json.RawMessage `json:"data"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+func (c *client) GetLastHit(ctx context.Context, recordID int64) (*Hit, error) {
+	rows, err := dbHandler.Query("SELECT record_id, data, created_at FROM last_hit WHERE record_id = ?", recordID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, nil
+	}
+	hit := &Hit{}
+	var raw string
+	var createdAt int64
+	if err := rows.Scan(&hit.RecordID, &raw, &createdAt); err != nil {
+		return nil, err
+	}
+	hit.Data = json.RawMessage(raw)
+	hit.CreatedAt = time.Unix(createdAt, 0)
+	return hit, nil
+}
+
+func (c *client) SaveLastHit(ctx context.Context, hit *Hit) error {
+	_, err := dbHandler.Exec("INSERT OR REPLACE INTO last_hit (record_id, data, created_at) VALUES (?, ?, ?)", hit.RecordID, string(hit.Data), hit.CreatedAt.Unix())
+	return err
+}
@@ -3,7 +3,10 @@ package dao
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"fmt"
 	"sync"
+	"time"
 
 	"github.com/ink19/poewatcher/config"
 	"github.com/sirupsen/logrus"
@@ -18,6 +21,50 @@ const (
 	RecordStatusError
 )
 
+type RecordKindEnum int
+
+const (
+	// RecordKindLiveSearch 物品直播搜索
+	RecordKindLiveSearch RecordKindEnum = iota
+	// RecordKindExchange 通货批量兑换，轮询
+	RecordKindExchange
+)
+
+type RecordModeEnum int
+
+const (
+	// RecordModeLive 直播搜索
+	RecordModeLive RecordModeEnum = iota
+	// RecordModePoll 定时重新搜索，对比结果中新出现的物品
+	RecordModePoll
+	// RecordModeAuto 优先直播搜索，连接不可用或者连接数不足时轮询
+	RecordModeAuto
+)
+
+type ExchangeParam struct {
+	Have []string `json:"have"`
+	Want []string `json:"want"`
+	// Ratio 每得到一个want最多支付的have数量，报价低于等于该值时通知
+	Ratio   float64 `json:"ratio"`
+	Minimum int     `json:"minimum,omitempty"`
+}
+
+// NotifyTemplate 通知消息的text/template模板，为空时使用全局配置或者默认模板
+type NotifyTemplate struct {
+	Text     string `json:"text,omitempty"`
+	Markdown string `json:"markdown,omitempty"`
+}
+
+// DigestParam 汇总通知，第一个物品到达后Window秒内或者攒满Max个物品时合并为一条消息
+type DigestParam struct {
+	// Window 汇总窗口，单位：秒，为0时为60秒
+	Window int `json:"window"`
+	// Max 攒满后立即发送，为0时不限
+	Max int `json:"max,omitempty"`
+	// Full 价格最低的几个物品显示完整内容，为0时为3
+	Full int `json:"full,omitempty"`
+}
+
 type Record struct {
 	ID       int64            `json:"id"`
 	Name     string           `json:"name"`
@@ -25,14 +72,63 @@ type Record struct {
 	SearchID string           `json:"search_id"`
 	Cookie   string           `json:"cookie"`
 	Status   RecordStatusEnum `json:"status"`
+	// Query 交易搜索的查询JSON，设置后SearchID过期时会自动重新搜索
+	Query json.RawMessage `json:"query,omitempty"`
+	Kind  RecordKindEnum  `json:"kind"`
+	// Exchange Kind为RecordKindExchange时有效
+	Exchange *ExchangeParam `json:"exchange,omitempty"`
+	// Realm 交易站点，为空时使用配置的默认站点
+	Realm string `json:"realm,omitempty"`
+	// Account 使用账号池中的账号，Cookie不为空时优先使用Cookie
+	Account string `json:"account,omitempty"`
+	// Reason Status为RecordStatusError时的错误原因
+	Reason string `json:"reason,omitempty"`
+	// Priority 直播搜索连接数不足时优先级高的记录优先监听
+	Priority int `json:"priority"`
+	// Proxy 记录使用的代理，优先于账号和全局配置
+	Proxy string `json:"proxy,omitempty"`
+	// Mode Kind为RecordKindLiveSearch时有效，轮询需要设置Query
+	Mode RecordModeEnum `json:"mode"`
+	// Filter 过滤表达式，获取详情后不满足的物品不通知，Kind为RecordKindLiveSearch时有效
+	Filter string `json:"filter,omitempty"`
+	// Notifiers 通知目标的名称，对应配置中的notify.targets，为空时使用默认目标
+	Notifiers []string `json:"notifiers,omitempty"`
+	// Template 通知消息模板，Kind为RecordKindLiveSearch时有效
+	Template *NotifyTemplate `json:"template,omitempty"`
+	// Digest 设置后汇总通知，Kind为RecordKindLiveSearch时有效
+	Digest *DigestParam `json:"digest,omitempty"`
 }
 
 type Client interface {
 	AddRecord(ctx context.Context, record *Record) error
 	UpdateRecordStatus(ctx context.Context, id int64, status RecordStatusEnum) error
+	UpdateRecordSearchID(ctx context.Context, id int64, searchID string) error
+	UpdateRecordCookie(ctx context.Context, id int64, cookie string) error
+	// SetRecordError 设置状态为RecordStatusError并记录原因
+	SetRecordError(ctx context.Context, id int64, reason string) error
 	GetRecord(ctx context.Context, id int64) (*Record, error)
 	ListRecords(ctx context.Context) ([]*Record, error)
 	DeleteRecord(ctx context.Context, id int64) error
+
+	// FilterSeenItems 过滤掉since之后已经通知过的物品ID
+	FilterSeenItems(ctx context.Context, recordID int64, itemIDs []string, since time.Time) ([]string, error)
+	// AddSeenItems 标记物品ID已经通知过
+	AddSeenItems(ctx context.Context, recordID int64, itemIDs []string, at time.Time) error
+	// DeleteSeenItems 删除before之前的标记
+	DeleteSeenItems(ctx context.Context, recordID int64, before time.Time) error
+
+	// GetLastHit 没有命中时返回nil
+	GetLastHit(ctx context.Context, recordID int64) (*Hit, error)
+	// SaveLastHit 保存最近一次通知的物品，用于预览模板
+	SaveLastHit(ctx context.Context, hit *Hit) error
+
+	// GetTradeData 没有缓存时返回nil
+	GetTradeData(ctx context.Context, realm string, kind string) (*TradeData, error)
+	SaveTradeData(ctx context.Context, data *TradeData) error
+
+	// GetCurrencyRates 没有缓存时返回nil
+	GetCurrencyRates(ctx context.Context, league string) (*CurrencyRates, error)
+	SaveCurrencyRates(ctx context.Context, rates *CurrencyRates) error
 }
 
 type client struct{}
@@ -62,10 +158,143 @@ func createTableIfNotExists() {
 		logrus.Errorf("create table record error: %s", err)
 		panic(err)
 	}
+	addColumnIfNotExists("record", "query", "TEXT NOT NULL DEFAULT ''")
+	addColumnIfNotExists("record", "kind", "INTEGER NOT NULL DEFAULT 0")
+	addColumnIfNotExists("record", "exchange", "TEXT NOT NULL DEFAULT ''")
+	addColumnIfNotExists("record", "realm", "TEXT NOT NULL DEFAULT ''")
+	addColumnIfNotExists("record", "account", "TEXT NOT NULL DEFAULT ''")
+	addColumnIfNotExists("record", "reason", "TEXT NOT NULL DEFAULT ''")
+	addColumnIfNotExists("record", "priority", "INTEGER NOT NULL DEFAULT 0")
+	addColumnIfNotExists("record", "proxy", "TEXT NOT NULL DEFAULT ''")
+	addColumnIfNotExists("record", "mode", "INTEGER NOT NULL DEFAULT 0")
+	addColumnIfNotExists("record", "filter", "TEXT NOT NULL DEFAULT ''")
+	addColumnIfNotExists("record", "notifiers", "TEXT NOT NULL DEFAULT ''")
+	addColumnIfNotExists("record", "template", "TEXT NOT NULL DEFAULT ''")
+	addColumnIfNotExists("record", "digest", "TEXT NOT NULL DEFAULT ''")
+
+	_, err = dbHandler.Exec("CREATE TABLE IF NOT EXISTS seen_item (record_id INTEGER NOT NULL, item_id TEXT NOT NULL, seen_at INTEGER NOT NULL, PRIMARY KEY (record_id, item_id))")
+	if err != nil {
+		logrus.Errorf("create table seen_item error: %s", err)
+		panic(err)
+	}
+
+	_, err = dbHandler.Exec("CREATE TABLE IF NOT EXISTS trade_data (realm TEXT NOT NULL, kind TEXT NOT NULL, data TEXT NOT NULL, updated_at INTEGER NOT NULL, PRIMARY KEY (realm, kind))")
+	if err != nil {
+		logrus.Errorf("create table trade_data error: %s", err)
+		panic(err)
+	}
+
+	_, err = dbHandler.Exec("CREATE TABLE IF NOT EXISTS last_hit (record_id INTEGER PRIMARY KEY, data TEXT NOT NULL, created_at INTEGER NOT NULL)")
+	if err != nil {
+		logrus.Errorf("create table last_hit error: %s", err)
+		panic(err)
+	}
+
+	_, err = dbHandler.Exec("CREATE TABLE IF NOT EXISTS currency_rate (league TEXT PRIMARY KEY, source TEXT NOT NULL, data TEXT NOT NULL, updated_at INTEGER NOT NULL)")
+	if err != nil {
+		logrus.Errorf("create table currency_rate error: %s", err)
+		panic(err)
+	}
+}
+
+func addColumnIfNotExists(table string, column string, def string) {
+	rows, err := dbHandler.Query(fmt.Sprintf("SELECT name FROM pragma_table_info('%s')", table))
+	if err != nil {
+		logrus.Errorf("get table %s info error: %s", table, err)
+		panic(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			logrus.Errorf("scan table %s info error: %s", table, err)
+			panic(err)
+		}
+		if name == column {
+			return
+		}
+	}
+	rows.Close()
+
+	_, err = dbHandler.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, def))
+	if err != nil {
+		logrus.Errorf("add column %s.%s error: %s", table, column, err)
+		panic(err)
+	}
+}
+
+const recordColumns = "id, name, season_id, search_id, cookie, status, query, kind, exchange, realm, account, reason, priority, proxy, mode, filter, notifiers, template, digest"
+
+func scanRecord(rows *sql.Rows) (*Record, error) {
+	record := &Record{}
+	var query, exchange, notifiers, template, digest string
+	err := rows.Scan(&record.ID, &record.Name, &record.SeasonID, &record.SearchID, &record.Cookie, &record.Status, &query, &record.Kind, &exchange, &record.Realm, &record.Account, &record.Reason, &record.Priority, &record.Proxy, &record.Mode, &record.Filter, &notifiers, &template, &digest)
+	if err != nil {
+		return nil, err
+	}
+	if query != "" {
+		record.Query = json.RawMessage(query)
+	}
+	if exchange != "" {
+		record.Exchange = &ExchangeParam{}
+		if err := json.Unmarshal([]byte(exchange), record.Exchange); err != nil {
+			return nil, err
+		}
+	}
+	if notifiers != "" {
+		if err := json.Unmarshal([]byte(notifiers), &record.Notifiers); err != nil {
+			return nil, err
+		}
+	}
+	if template != "" {
+		record.Template = &NotifyTemplate{}
+		if err := json.Unmarshal([]byte(template), record.Template); err != nil {
+			return nil, err
+		}
+	}
+	if digest != "" {
+		record.Digest = &DigestParam{}
+		if err := json.Unmarshal([]byte(digest), record.Digest); err != nil {
+			return nil, err
+		}
+	}
+	return record, nil
 }
 
 func (c *client) AddRecord(ctx context.Context, record *Record) error {
-	dbRsp, err := dbHandler.Exec("INSERT INTO record (name, season_id, search_id, cookie, status) VALUES (?, ?, ?, ?, ?)", record.Name, record.SeasonID, record.SearchID, record.Cookie, record.Status)
+	exchange := ""
+	if record.Exchange != nil {
+		b, err := json.Marshal(record.Exchange)
+		if err != nil {
+			return err
+		}
+		exchange = string(b)
+	}
+	notifiers := ""
+	if len(record.Notifiers) > 0 {
+		b, err := json.Marshal(record.Notifiers)
+		if err != nil {
+			return err
+		}
+		notifiers = string(b)
+	}
+	template := ""
+	if record.Template != nil {
+		b, err := json.Marshal(record.Template)
+		if err != nil {
+			return err
+		}
+		template = string(b)
+	}
+	digest := ""
+	if record.Digest != nil {
+		b, err := json.Marshal(record.Digest)
+		if err != nil {
+			return err
+		}
+		digest = string(b)
+	}
+	dbRsp, err := dbHandler.Exec("INSERT INTO record (name, season_id, search_id, cookie, status, query, kind, exchange, realm, account, priority, proxy, mode, filter, notifiers, template, digest) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", record.Name, record.SeasonID, record.SearchID, record.Cookie, record.Status, string(record.Query), record.Kind, exchange, record.Realm, record.Account, record.Priority, record.Proxy, record.Mode, record.Filter, notifiers, template, digest)
 	if err != nil {
 		return err
 	}
@@ -74,37 +303,46 @@ func (c *client) AddRecord(ctx context.Context, record *Record) error {
 }
 
 func (c *client) UpdateRecordStatus(ctx context.Context, id int64, status RecordStatusEnum) error {
-	_, err := dbHandler.Exec("UPDATE record SET status = ? WHERE id = ?", status, id)
+	_, err := dbHandler.Exec("UPDATE record SET status = ?, reason = '' WHERE id = ?", status, id)
+	return err
+}
+
+func (c *client) SetRecordError(ctx context.Context, id int64, reason string) error {
+	_, err := dbHandler.Exec("UPDATE record SET status = ?, reason = ? WHERE id = ?", RecordStatusError, reason, id)
+	return err
+}
+
+func (c *client) UpdateRecordCookie(ctx context.Context, id int64, cookie string) error {
+	_, err := dbHandler.Exec("UPDATE record SET cookie = ? WHERE id = ?", cookie, id)
+	return err
+}
+
+func (c *client) UpdateRecordSearchID(ctx context.Context, id int64, searchID string) error {
+	_, err := dbHandler.Exec("UPDATE record SET search_id = ? WHERE id = ?", searchID, id)
 	return err
 }
 
 func (c *client) GetRecord(ctx context.Context, id int64) (*Record, error) {
-	rows, err := dbHandler.Query("SELECT id, name, season_id, search_id, cookie, status FROM record WHERE id = ?", id)
+	rows, err := dbHandler.Query("SELECT "+recordColumns+" FROM record WHERE id = ?", id)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 	if rows.Next() {
-		record := &Record{}
-		err := rows.Scan(&record.ID, &record.Name, &record.SeasonID, &record.SearchID, &record.Cookie, &record.Status)
-		if err != nil {
-			return nil, err
-		}
-		return record, nil
+		return scanRecord(rows)
 	}
 	return nil, nil
 }
 
 func (c *client) ListRecords(ctx context.Context) ([]*Record, error) {
-	rows, err := dbHandler.Query("SELECT id, name, season_id, search_id, cookie, status FROM record")
+	rows, err := dbHandler.Query("SELECT " + recordColumns + " FROM record")
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 	var records []*Record
 	for rows.Next() {
-		record := &Record{}
-		err := rows.Scan(&record.ID, &record.Name, &record.SeasonID, &record.SearchID, &record.Cookie, &record.Status)
+		record, err := scanRecord(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -115,5 +353,13 @@ func (c *client) ListRecords(ctx context.Context) ([]*Record, error) {
 
 func (c *client) DeleteRecord(ctx context.Context, id int64) error {
 	_, err := dbHandler.Exec("DELETE FROM record WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	_, err = dbHandler.Exec("DELETE FROM seen_item WHERE record_id = ?", id)
+	if err != nil {
+		return err
+	}
+	_, err = dbHandler.Exec("DELETE FROM last_hit WHERE record_id = ?", id)
 	return err
 }
@@ -0,0 +1,67 @@
+package dao
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/ink19/poewatcher/config"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "poewatcher-dao")
+	if err != nil {
+		panic(err)
+	}
+	config.Get().DB.Path = filepath.Join(dir, "test.db")
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// TestRecordRoundTrip 保存后重新读取，JSON字段和保存前相同
+func TestRecordRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	c := NewClient()
+	record := &Record{
+		Name:      "round trip",
+		SeasonID:  "Standard",
+		SearchID:  "abc",
+		Kind:      RecordKindLiveSearch,
+		Filter:    `price.divine < 1`,
+		Notifiers: []string{"wx"},
+		Template:  &NotifyTemplate{Text: "{{.Desc}}", Markdown: "**{{.Record}}**"},
+		Digest:    &DigestParam{Window: 30, Max: 5, Full: 2},
+	}
+	if err := c.AddRecord(ctx, record); err != nil {
+		t.Fatalf("AddRecord fail, err: %v", err)
+	}
+	got, err := c.GetRecord(ctx, record.ID)
+	if err != nil || got == nil {
+		t.Fatalf("GetRecord = %+v, err: %v", got, err)
+	}
+	if !reflect.DeepEqual(got, record) {
+		t.Errorf("GetRecord = %+v, want %+v", got, record)
+	}
+
+	records, err := c.ListRecords(ctx)
+	if err != nil {
+		t.Fatalf("ListRecords fail, err: %v", err)
+	}
+	found := false
+	for _, r := range records {
+		if r.ID == record.ID {
+			found = true
+			if !reflect.DeepEqual(r, record) {
+				t.Errorf("ListRecords = %+v, want %+v", r, record)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("ListRecords missing record %d", record.ID)
+	}
+}
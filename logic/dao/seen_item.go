@@ -0,0 +1,72 @@
+package dao
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// FilterSeenItems 返回since之后没有标记过的ID，保持原来的顺序
+func (c *client) FilterSeenItems(ctx context.Context, recordID int64, itemIDs []string, since time.Time) ([]string, error) {
+	if len(itemIDs) == 0 {
+		return itemIDs, nil
+	}
+	args := []interface{}{recordID, since.Unix()}
+	for _, id := range itemIDs {
+		args = append(args, id)
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(itemIDs)), ", ")
+	rows, err := dbHandler.Query("SELECT item_id FROM seen_item WHERE record_id = ? AND seen_at >= ? AND item_id IN ("+placeholders+")", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	seen := map[string]struct{}{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		seen[id] = struct{}{}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	res := make([]string, 0, len(itemIDs))
+	for _, id := range itemIDs {
+		if _, ok := seen[id]; !ok {
+			res = append(res, id)
+		}
+	}
+	return res, nil
+}
+
+// AddSeenItems 在同一个事务中写入，避免每个ID提交一次
+func (c *client) AddSeenItems(ctx context.Context, recordID int64, itemIDs []string, at time.Time) error {
+	if len(itemIDs) == 0 {
+		return nil
+	}
+	tx, err := dbHandler.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.PrepareContext(ctx, "INSERT OR REPLACE INTO seen_item (record_id, item_id, seen_at) VALUES (?, ?, ?)")
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+	for _, id := range itemIDs {
+		if _, err := stmt.ExecContext(ctx, recordID, id, at.Unix()); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (c *client) DeleteSeenItems(ctx context.Context, recordID int64, before time.Time) error {
+	_, err := dbHandler.Exec("DELETE FROM seen_item WHERE record_id = ? AND seen_at < ?", recordID, before.Unix())
+	return err
+}
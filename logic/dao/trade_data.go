@@ -0,0 +1,40 @@
+package dao
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// TradeData 交易站点静态数据的缓存，Data为接口返回的result数组
+type TradeData struct {
+	Realm     string          `json:"realm"`
+	Kind      string          `json:"kind"`
+	Data      json.RawMessage `json:"data"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+func (c *client) GetTradeData(ctx context.Context, realm string, kind string) (*TradeData, error) {
+	rows, err := dbHandler.Query("SELECT realm, kind, data, updated_at FROM trade_data WHERE realm = ? AND kind = ?", realm, kind)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, nil
+	}
+	data := &TradeData{}
+	var raw string
+	var updatedAt int64
+	if err := rows.Scan(&data.Realm, &data.Kind, &raw, &updatedAt); err != nil {
+		return nil, err
+	}
+	data.Data = json.RawMessage(raw)
+	data.UpdatedAt = time.Unix(updatedAt, 0)
+	return data, nil
+}
+
+func (c *client) SaveTradeData(ctx context.Context, data *TradeData) error {
+	_, err := dbHandler.Exec("INSERT OR REPLACE INTO trade_data (realm, kind, data, updated_at) VALUES (?, ?, ?, ?)", data.Realm, data.Kind, string(data.Data), data.UpdatedAt.Unix())
+	return err
+}
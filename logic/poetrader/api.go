@@ -2,67 +2,164 @@ package poetrader
 
 import (
 	"context"
+	"encoding/json"
 	"net/http"
+	"net/url"
 	"sync"
+	"time"
 
-	"github.com/ink19/poewatcher/config"
-	"golang.org/x/time/rate"
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
 )
 
-type PoeItemExtended struct {
-	DescText string `json:"text"`
+type Client interface {
+	GetInfo(ctx context.Context, searchID string, goodID string) (*PoeGood, error)
+	BatchGetInfo(ctx context.Context, searchID string, goodIDs []string) ([]*PoeGood, error)
+	Search(ctx context.Context, query json.RawMessage) (*SearchRes, error)
+	Exchange(ctx context.Context, query *ExchangeQuery) (*ExchangeRes, error)
+	// CheckSession 检查cookie是否有效，有效时返回账号名
+	CheckSession(ctx context.Context) (string, error)
+	// TradeData 获取联盟、词缀、物品、通货等静态数据，kind见DataKinds
+	TradeData(ctx context.Context, kind string) (json.RawMessage, error)
+	Watch(ctx context.Context, searchID string) (<-chan *PoeGood, error)
+	// Err 返回导致Watch退出的错误，正常停止时为nil
+	Err() error
+	Stop(ctx context.Context) error
 }
 
-type PoeItem struct {
-	Extended PoeItemExtended `json:"extended"`
-}
+// ReconnectHandler 每次重连前调用，attempt从1开始
+type ReconnectHandler func(ctx context.Context, attempt int, delay time.Duration, err error)
 
-type PoeListing struct {
-	Price PoePrice `json:"price"`
+// ReconnectedHandler 重连成功后调用，attempt为成功时的重连次数
+type ReconnectedHandler func(ctx context.Context, attempt int)
+
+type Option func(c *client)
+
+// WithRealm 指定交易站点，不指定时使用配置的默认站点
+func WithRealm(realm *Realm) Option {
+	return func(c *client) {
+		c.realm = realm
+	}
 }
 
-type PoePrice struct {
-	Type     string `json:"type"`
-	Amount   int    `json:"amount"`
-	Currency string `json:"divine"`
+func WithReconnectHandler(h ReconnectHandler) Option {
+	return func(c *client) {
+		c.onReconnect = h
+	}
 }
 
-type PoeGood struct {
-	ID      string     `json:"id"`
-	Listing PoeListing `json:"listing"`
-	Item    PoeItem    `json:"Item"`
+func WithReconnectedHandler(h ReconnectedHandler) Option {
+	return func(c *client) {
+		c.onReconnected = h
+	}
 }
 
-type Client interface {
-	GetInfo(ctx context.Context, searchID string, goodID string) (*PoeGood, error)
-	Watch(ctx context.Context, searchID string) (<-chan *PoeGood, error)
-	Stop(ctx context.Context) error
+// WithAccount 指定账号名，用于区分限频状态，不指定时根据cookie生成
+func WithAccount(account string) Option {
+	return func(c *client) {
+		c.account = account
+	}
 }
 
-var (
-	dbOnce = &sync.Once{}
-	rateLimit *rate.Limiter
-)
+// WithRecorder 把请求、响应和直播搜索消息写入cassette，用于离线复现问题
+func WithRecorder(r *Recorder) Option {
+	return func(c *client) {
+		c.recorder = r
+	}
+}
 
-func New(seasonID string, cookies string) Client {
-	dbOnce.Do(func() {
-		rateLimit = rate.NewLimiter(rate.Limit(config.Get().Poe.RateLimit), config.Get().Poe.RateLimit)
-	})
+// WithProxy 使用代理访问交易站点，为nil时直连
+func WithProxy(proxyURL *url.URL) Option {
+	return func(c *client) {
+		c.proxy = proxyURL
+	}
+}
 
-	return &client{
+func New(seasonID string, cookies string, opts ...Option) Client {
+	c := &client{
 		cookies:  cookies,
-		header:   GetSimHeader(cookies),
 		seasonID: seasonID,
 		stopChan: make(chan struct{}),
+		stopOnce: &sync.Once{},
 		wg: sync.WaitGroup{},
 	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.realm == nil {
+		realm, err := GetRealm("")
+		if err != nil {
+			log.Errorf("GetRealm fail, use %s, err: %v", RealmTencent, err)
+			realm, _ = GetRealm(RealmTencent)
+		}
+		c.realm = realm
+	}
+	c.header = GetSimHeader(c.realm, cookies)
+	if c.account == "" {
+		c.account = AccountKey(cookies)
+	}
+	c.limiter = getAccountLimiter(c.account)
+	c.ipLimiter = getIPLimiter()
+	c.httpClient = newHTTPClient(c.proxy)
+	c.wsDialer = websocket.DefaultDialer
+	if c.proxy != nil {
+		c.wsDialer = &websocket.Dialer{
+			NetDialContext:   proxyDialContext(c.proxy),
+			HandshakeTimeout: websocket.DefaultDialer.HandshakeTimeout,
+		}
+	}
+	c.dialWS = c.dialWebsocket
+	if c.replay != nil {
+		// 回放时不需要限频，也不影响真实账号的限频状态
+		c.limiter = NewRateLimiter(0, false)
+		c.ipLimiter = NewRateLimiter(0, true)
+		c.httpClient = &http.Client{Transport: c.replay}
+		c.dialWS = c.replay.dial
+	}
+	if c.recorder != nil {
+		c.recorder.recordMeta(c.seasonID, c.realm)
+		c.httpClient = &http.Client{Transport: c.recorder.transport(c.httpClient.Transport)}
+		c.dialWS = c.recorder.dialer(c.dialWS)
+	}
+	return c
 }
 
 type client struct {
 	cookies  string
 	seasonID string
+	realm    *Realm
+	account  string
+	limiter  *RateLimiter
+	// ipLimiter 所有账号共享的IP限频
+	ipLimiter *RateLimiter
+
+	proxy      *url.URL
+	httpClient *http.Client
+	wsDialer   *websocket.Dialer
+	dialWS     wsDialFunc
+
+	recorder *Recorder
+	replay   *cassette
 
 	header *http.Header
 	stopChan chan struct{}
+	stopOnce *sync.Once
 	wg sync.WaitGroup
+
+	onReconnect ReconnectHandler
+	onReconnected ReconnectedHandler
+	errLock sync.Mutex
+	err error
+}
+
+func (c *client) Err() error {
+	c.errLock.Lock()
+	defer c.errLock.Unlock()
+	return c.err
+}
+
+func (c *client) setErr(err error) {
+	c.errLock.Lock()
+	defer c.errLock.Unlock()
+	c.err = err
 }
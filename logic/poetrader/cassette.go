@@ -0,0 +1,197 @@
+package poetrader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// cassette中每一行的类型
+const (
+	interactionMeta    = "meta"
+	interactionHTTP    = "http"
+	interactionWSDial  = "ws_dial"
+	interactionWSFrame = "ws_frame"
+	// interactionWSError 连接读取失败，之后该连接不会再有消息
+	interactionWSError = "ws_error"
+)
+
+const redacted = "REDACTED"
+
+// Interaction cassette文件中的一行
+type Interaction struct {
+	Seq  int       `json:"seq"`
+	Time time.Time `json:"time"`
+	Kind string    `json:"kind"`
+
+	// meta
+	SeasonID string `json:"season_id,omitempty"`
+	Realm    *Realm `json:"realm,omitempty"`
+
+	Method         string      `json:"method,omitempty"`
+	URL            string      `json:"url,omitempty"`
+	RequestHeader  http.Header `json:"request_header,omitempty"`
+	RequestBody    string      `json:"request_body,omitempty"`
+	Status         int         `json:"status,omitempty"`
+	ResponseHeader http.Header `json:"response_header,omitempty"`
+	ResponseBody   string      `json:"response_body,omitempty"`
+	// Conn 直播搜索连接的编号，同一连接的消息编号相同
+	Conn        int    `json:"conn,omitempty"`
+	MessageType int    `json:"message_type,omitempty"`
+	Message     string `json:"message,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// Recorder 把交易站点的HTTP请求、响应和直播搜索消息按JSON行写入cassette文件
+// cookie等敏感请求头会被替换，响应中的Set-Cookie会被删除
+type Recorder struct {
+	lock sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+	seq  int
+	conn int
+}
+
+func NewRecorder(path string) (*Recorder, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &Recorder{file: file, enc: json.NewEncoder(file)}, nil
+}
+
+func (r *Recorder) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.file.Close()
+}
+
+func (r *Recorder) write(i *Interaction) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.seq++
+	i.Seq = r.seq
+	i.Time = time.Now()
+	// 录制失败不影响正常请求
+	_ = r.enc.Encode(i)
+}
+
+func (r *Recorder) nextConn() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.conn++
+	return r.conn
+}
+
+func (r *Recorder) recordMeta(seasonID string, realm *Realm) {
+	r.write(&Interaction{Kind: interactionMeta, SeasonID: seasonID, Realm: realm})
+}
+
+func redactHeader(header http.Header) http.Header {
+	if header == nil {
+		return nil
+	}
+	res := header.Clone()
+	for _, k := range []string{"Cookie", "Authorization", "Proxy-Authorization"} {
+		if res.Get(k) != "" {
+			res.Set(k, redacted)
+		}
+	}
+	res.Del("Set-Cookie")
+	return res
+}
+
+func (r *Recorder) transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &recordingTransport{recorder: r, base: base}
+}
+
+type recordingTransport struct {
+	recorder *Recorder
+	base     http.RoundTripper
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	i := &Interaction{
+		Kind:          interactionHTTP,
+		Method:        req.Method,
+		URL:           req.URL.String(),
+		RequestHeader: redactHeader(req.Header),
+	}
+	if req.GetBody != nil {
+		if body, err := req.GetBody(); err == nil {
+			b, _ := io.ReadAll(body)
+			i.RequestBody = string(b)
+		}
+	}
+
+	rsp, err := t.base.RoundTrip(req)
+	if err != nil {
+		i.Error = err.Error()
+		t.recorder.write(i)
+		return nil, err
+	}
+	body, err := io.ReadAll(rsp.Body)
+	rsp.Body.Close()
+	if err != nil {
+		i.Error = err.Error()
+	}
+	rsp.Body = io.NopCloser(bytes.NewReader(body))
+	i.Status = rsp.StatusCode
+	i.ResponseHeader = redactHeader(rsp.Header)
+	i.ResponseBody = string(body)
+	t.recorder.write(i)
+	return rsp, nil
+}
+
+func (r *Recorder) dialer(dial wsDialFunc) wsDialFunc {
+	return func(ctx context.Context, url string, header http.Header) (wsConn, *http.Response, error) {
+		conn, rsp, err := dial(ctx, url, header)
+		i := &Interaction{
+			Kind:          interactionWSDial,
+			Conn:          r.nextConn(),
+			URL:           url,
+			RequestHeader: redactHeader(header),
+		}
+		if rsp != nil {
+			i.Status = rsp.StatusCode
+			i.ResponseHeader = redactHeader(rsp.Header)
+			if err != nil && rsp.Body != nil {
+				body, _ := io.ReadAll(rsp.Body)
+				rsp.Body.Close()
+				rsp.Body = io.NopCloser(bytes.NewReader(body))
+				i.ResponseBody = string(body)
+			}
+		}
+		if err != nil {
+			i.Error = err.Error()
+			r.write(i)
+			return nil, rsp, err
+		}
+		r.write(i)
+		return &recordingConn{wsConn: conn, recorder: r, id: i.Conn}, rsp, nil
+	}
+}
+
+type recordingConn struct {
+	wsConn
+	recorder *Recorder
+	id       int
+}
+
+func (c *recordingConn) ReadMessage() (int, []byte, error) {
+	mt, data, err := c.wsConn.ReadMessage()
+	if err != nil {
+		c.recorder.write(&Interaction{Kind: interactionWSError, Conn: c.id, Error: err.Error()})
+		return mt, data, err
+	}
+	c.recorder.write(&Interaction{Kind: interactionWSFrame, Conn: c.id, MessageType: mt, Message: string(data)})
+	return mt, data, err
+}
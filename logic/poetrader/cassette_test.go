@@ -0,0 +1,88 @@
+package poetrader_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ink19/poewatcher/logic/poetrader"
+	"github.com/ink19/poewatcher/logic/poetrader/poetradertest"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	srv := poetradertest.NewServer()
+	defer srv.Close()
+	srv.AddGoods(poetradertest.NewGood("a", "item a"))
+	path := filepath.Join(t.TempDir(), "cassette.jsonl")
+
+	recorder, err := poetrader.NewRecorder(path)
+	if err != nil {
+		t.Fatalf("NewRecorder fail, err: %v", err)
+	}
+	c := newTestClient(t, srv, poetrader.WithRecorder(recorder))
+	if _, err := c.GetInfo(context.Background(), "abc", "a"); err != nil {
+		t.Fatalf("GetInfo fail, err: %v", err)
+	}
+	srv.Enqueue(poetradertest.EndpointFetch, poetradertest.Status(401))
+	if _, err := c.GetInfo(context.Background(), "abc", "a"); !errors.Is(err, poetrader.ErrUnauthorized) {
+		t.Fatalf("GetInfo err = %v, want ErrUnauthorized", err)
+	}
+	ch, err := c.Watch(context.Background(), "abc")
+	if err != nil {
+		t.Fatalf("Watch fail, err: %v", err)
+	}
+	conn := nextLive(t, srv)
+	if err := conn.Push("b", "c"); err != nil {
+		t.Fatalf("Push fail, err: %v", err)
+	}
+	recvGood(t, ch)
+	recvGood(t, ch)
+	conn.Close()
+	// 等待重连后停止，断开事件也会被录制
+	nextLive(t, srv)
+	_ = c.Stop(context.Background())
+	waitClosed(t, ch)
+	if err := recorder.Close(); err != nil {
+		t.Fatalf("Close fail, err: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile fail, err: %v", err)
+	}
+	if strings.Contains(string(data), "POESESSID") {
+		t.Errorf("cookie is not redacted")
+	}
+
+	replay, err := poetrader.NewReplayClient(path)
+	if err != nil {
+		t.Fatalf("NewReplayClient fail, err: %v", err)
+	}
+	defer replay.Stop(context.Background())
+	good, err := replay.GetInfo(context.Background(), "abc", "a")
+	if err != nil || good.ID != "a" {
+		t.Fatalf("replay GetInfo = %v, %v", good, err)
+	}
+	if _, err := replay.GetInfo(context.Background(), "abc", "a"); !errors.Is(err, poetrader.ErrUnauthorized) {
+		t.Errorf("replay GetInfo err = %v, want ErrUnauthorized", err)
+	}
+	if _, err := replay.GetInfo(context.Background(), "abc", "a"); !errors.Is(err, poetrader.ErrReplayExhausted) {
+		t.Errorf("replay GetInfo err = %v, want ErrReplayExhausted", err)
+	}
+
+	replayCh, err := replay.Watch(context.Background(), "abc")
+	if err != nil {
+		t.Fatalf("replay Watch fail, err: %v", err)
+	}
+	for _, want := range []string{"b", "c"} {
+		if good := recvGood(t, replayCh); good.ID != want {
+			t.Errorf("replay good id = %s, want %s", good.ID, want)
+		}
+	}
+	// 第二个连接没有消息，停止后录制的连接都已用完
+	_ = replay.Stop(context.Background())
+	waitClosed(t, replayCh)
+}
@@ -0,0 +1,101 @@
+package poetrader
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// 交易站点的静态数据，对应 /api/trade/data/{kind}
+const (
+	DataLeagues = "leagues"
+	DataStats   = "stats"
+	DataItems   = "items"
+	DataStatic  = "static"
+)
+
+var DataKinds = []string{DataLeagues, DataStats, DataItems, DataStatic}
+
+func IsDataKind(kind string) bool {
+	for _, k := range DataKinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+type League struct {
+	ID    string `json:"id"`
+	Realm string `json:"realm,omitempty"`
+	Text  string `json:"text"`
+}
+
+type StatGroup struct {
+	ID      string  `json:"id"`
+	Label   string  `json:"label"`
+	Entries []*Stat `json:"entries"`
+}
+
+// Stat ID形如 explicit.stat_3299347043，Text中的#为数值
+type Stat struct {
+	ID   string `json:"id"`
+	Text string `json:"text"`
+	Type string `json:"type"`
+}
+
+type ItemCategory struct {
+	ID      string       `json:"id"`
+	Label   string       `json:"label"`
+	Entries []*ItemEntry `json:"entries"`
+}
+
+type ItemEntry struct {
+	Name  string `json:"name,omitempty"`
+	Type  string `json:"type"`
+	Text  string `json:"text"`
+	Flags *struct {
+		Unique bool `json:"unique,omitempty"`
+	} `json:"flags,omitempty"`
+}
+
+type StaticGroup struct {
+	ID      string         `json:"id"`
+	Label   string         `json:"label"`
+	Entries []*StaticEntry `json:"entries"`
+}
+
+// StaticEntry 通货、碎片等，ID即兑换接口中使用的通货标签
+type StaticEntry struct {
+	ID    string `json:"id"`
+	Text  string `json:"text"`
+	Image string `json:"image,omitempty"`
+}
+
+type dataRes struct {
+	Result json.RawMessage `json:"result"`
+}
+
+// TradeData 返回静态数据的result数组，不需要登录
+func (c *client) TradeData(ctx context.Context, kind string) (json.RawMessage, error) {
+	if !IsDataKind(kind) {
+		return nil, fmt.Errorf("unknown trade data kind %s", kind)
+	}
+	rspBody, err := c.request(ctx, endpointData, c.realm.DataURL(kind))
+	if err != nil {
+		log.WithContext(ctx).Errorf("Request fail, err: %v", err)
+		return nil, err
+	}
+	res := &dataRes{}
+	err = json.Unmarshal(rspBody, res)
+	if err != nil {
+		log.WithContext(ctx).Errorf("Unmarshal fail, err: %v", err)
+		return nil, err
+	}
+	if len(res.Result) == 0 {
+		return nil, fmt.Errorf("%w: empty %s data", ErrInvalidResponse, kind)
+	}
+	return res.Result, nil
+}
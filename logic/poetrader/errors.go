@@ -0,0 +1,123 @@
+package poetrader
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrUnauthorized cookie失效或者直播搜索鉴权失败
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrForbidden 没有权限，或者账号、IP被封禁
+	ErrForbidden = errors.New("forbidden")
+	// ErrNotFound 搜索不存在或已过期
+	ErrNotFound = errors.New("not found")
+	// ErrRateLimited 触发限频，具体等待时间见 RetryAfter
+	ErrRateLimited = errors.New("rate limited")
+	// ErrBadRequest 请求参数错误，比如查询JSON不合法
+	ErrBadRequest = errors.New("bad request")
+	// ErrServer 服务端错误，可以稍后重试
+	ErrServer = errors.New("server error")
+	// ErrInvalidResponse 返回的不是JSON，比如维护页面或者验证页面
+	ErrInvalidResponse = errors.New("invalid response")
+	// ErrProxy 连接代理失败
+	ErrProxy = errors.New("proxy error")
+	// ErrStaleConnection 直播搜索连接没有响应或者长时间没有消息
+	ErrStaleConnection = errors.New("stale connection")
+	// ErrReplayExhausted 回放时cassette中没有匹配的请求或者连接
+	ErrReplayExhausted = errors.New("replay exhausted")
+)
+
+// StatusError 交易接口返回的错误，可以用errors.Is判断具体类型
+type StatusError struct {
+	StatusCode int
+	Message    string
+	RetryAfter time.Duration
+
+	err error
+}
+
+func (e *StatusError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("%v, status: %d", e.err, e.StatusCode)
+	}
+	return fmt.Sprintf("%v, status: %d, msg: %s", e.err, e.StatusCode, e.Message)
+}
+
+func (e *StatusError) Unwrap() error {
+	return e.err
+}
+
+type apiErrorRsp struct {
+	Error *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// newStatusError 根据状态码生成错误，状态码正常时返回nil
+func newStatusError(rsp *http.Response, body []byte) error {
+	if rsp.StatusCode >= 200 && rsp.StatusCode < 300 {
+		if isHTML(rsp, body) {
+			return &StatusError{StatusCode: rsp.StatusCode, err: ErrInvalidResponse}
+		}
+		return nil
+	}
+
+	e := &StatusError{StatusCode: rsp.StatusCode}
+	apiErr := &apiErrorRsp{}
+	if err := json.Unmarshal(body, apiErr); err == nil && apiErr.Error != nil {
+		e.Message = apiErr.Error.Message
+	}
+	switch {
+	case rsp.StatusCode == http.StatusUnauthorized:
+		e.err = ErrUnauthorized
+	case rsp.StatusCode == http.StatusForbidden:
+		e.err = ErrForbidden
+	case rsp.StatusCode == http.StatusNotFound:
+		e.err = ErrNotFound
+	case rsp.StatusCode == http.StatusTooManyRequests:
+		e.err = ErrRateLimited
+		e.RetryAfter = defaultRetryAfter
+		if v, err := strconv.Atoi(rsp.Header.Get("Retry-After")); err == nil && v > 0 {
+			e.RetryAfter = time.Duration(v) * time.Second
+		}
+	case rsp.StatusCode >= 500:
+		e.err = ErrServer
+	default:
+		e.err = ErrBadRequest
+	}
+	return e
+}
+
+func isHTML(rsp *http.Response, body []byte) bool {
+	if strings.HasPrefix(rsp.Header.Get("Content-Type"), "text/html") {
+		return true
+	}
+	return strings.HasPrefix(strings.TrimSpace(string(body)), "<")
+}
+
+// RetryAfter 返回限频错误需要等待的时间，其他错误返回0
+func RetryAfter(err error) time.Duration {
+	e := &StatusError{}
+	if errors.As(err, &e) && errors.Is(e, ErrRateLimited) {
+		return e.RetryAfter
+	}
+	return 0
+}
+
+// IsFatal 返回true时重试没有意义，需要人工处理
+func IsFatal(err error) bool {
+	return errors.Is(err, ErrUnauthorized) || errors.Is(err, ErrForbidden) || errors.Is(err, ErrNotFound) ||
+		errors.Is(err, ErrReplayExhausted)
+}
+
+// IsTemporary 返回true时可以稍后重试
+func IsTemporary(err error) bool {
+	return errors.Is(err, ErrRateLimited) || errors.Is(err, ErrServer) || errors.Is(err, ErrInvalidResponse)
+}
@@ -0,0 +1,121 @@
+package poetrader
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type ExchangeQuery struct {
+	Have    []string `json:"have"`
+	Want    []string `json:"want"`
+	Minimum int      `json:"minimum,omitempty"`
+}
+
+type exchangeReq struct {
+	Query struct {
+		Status struct {
+			Option string `json:"option"`
+		} `json:"status"`
+		Have    []string `json:"have"`
+		Want    []string `json:"want"`
+		Minimum int      `json:"minimum,omitempty"`
+	} `json:"query"`
+	Sort struct {
+		Have string `json:"have"`
+	} `json:"sort"`
+	Engine string `json:"engine"`
+}
+
+type ExchangeCurrency struct {
+	Currency string  `json:"currency"`
+	Amount   float64 `json:"amount"`
+	Stock    int     `json:"stock,omitempty"`
+	ID       string  `json:"id,omitempty"`
+	Whisper  string  `json:"whisper"`
+}
+
+// ExchangeOffer 卖家用Item换取Exchange，即我们支付Exchange得到Item
+type ExchangeOffer struct {
+	Exchange ExchangeCurrency `json:"exchange"`
+	Item     ExchangeCurrency `json:"item"`
+}
+
+// Ratio 每得到一个Item需要支付的Exchange数量
+func (o *ExchangeOffer) Ratio() float64 {
+	if o.Item.Amount == 0 {
+		return 0
+	}
+	return o.Exchange.Amount / o.Item.Amount
+}
+
+type ExchangeListing struct {
+	Indexed time.Time        `json:"indexed"`
+	Account *PoeAccount      `json:"account,omitempty"`
+	Offers  []*ExchangeOffer `json:"offers"`
+	Whisper string           `json:"whisper"`
+}
+
+// OfferWhisper 用报价填充密语模板
+func (l *ExchangeListing) OfferWhisper(o *ExchangeOffer) string {
+	itemWhisper := strings.ReplaceAll(o.Item.Whisper, "{0}", formatAmount(o.Item.Amount))
+	exchangeWhisper := strings.ReplaceAll(o.Exchange.Whisper, "{0}", formatAmount(o.Exchange.Amount))
+	whisper := strings.ReplaceAll(l.Whisper, "{0}", itemWhisper)
+	return strings.ReplaceAll(whisper, "{1}", exchangeWhisper)
+}
+
+type ExchangeResult struct {
+	ID      string           `json:"id"`
+	Listing *ExchangeListing `json:"listing"`
+}
+
+type ExchangeRes struct {
+	ID     string                     `json:"id"`
+	Result map[string]*ExchangeResult `json:"result"`
+	Total  int                        `json:"total"`
+	Error  *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+func (c *client) Exchange(ctx context.Context, query *ExchangeQuery) (*ExchangeRes, error) {
+	req := &exchangeReq{Engine: "new"}
+	req.Query.Status.Option = "online"
+	req.Query.Have = query.Have
+	req.Query.Want = query.Want
+	req.Query.Minimum = query.Minimum
+	req.Sort.Have = "asc"
+	reqBody, err := json.Marshal(req)
+	if err != nil {
+		log.WithContext(ctx).Errorf("Marshal fail, err: %v", err)
+		return nil, err
+	}
+
+	reqURL := c.realm.ExchangeURL(c.seasonID)
+	rspBody, err := c.postJSON(ctx, endpointExchange, reqURL, reqBody)
+	if err != nil {
+		log.WithContext(ctx).Errorf("Request fail, err: %v", err)
+		return nil, err
+	}
+	res := &ExchangeRes{}
+	err = json.Unmarshal(rspBody, res)
+	if err != nil {
+		log.WithContext(ctx).Errorf("Unmarshal fail, err: %v", err)
+		return nil, err
+	}
+	if res.Error != nil {
+		log.WithContext(ctx).Errorf("Exchange fail, code: %d, msg: %s", res.Error.Code, res.Error.Message)
+		return nil, fmt.Errorf("exchange fail, code: %d, msg: %s", res.Error.Code, res.Error.Message)
+	}
+	return res, nil
+}
+
+func formatAmount(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
@@ -10,15 +10,21 @@ const (
 	simUA = "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0.0.0 Safari/537.36"
 )
 
-func GetSimHeader(cookieStr string) *http.Header {
+func GetSimHeader(realm *Realm, cookieStr string) *http.Header {
 	header := http.Header{}
 	logrus.Debugf("cookie: %s", cookieStr)
 	header.Add("Cookie", cookieStr)
-	header.Add("Host", "poe.game.qq.com")
+	if realm.Host != "" {
+		header.Add("Host", realm.Host)
+	}
 	header.Add("Pragma", "no-cache")
 	header.Add("Cache-Control", "no-cache")
 	header.Add("User-Agent", simUA)
-	header.Add("Origin", "https://poe.game.qq.com")
-	header.Add("Accept-Language", "zh-CN,zh;q=0.9,en;q=0.8")
+	if realm.Origin != "" {
+		header.Add("Origin", realm.Origin)
+	}
+	if realm.Language != "" {
+		header.Add("Accept-Language", realm.Language)
+	}
 	return &header
 }
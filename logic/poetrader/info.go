@@ -4,27 +4,38 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"strings"
 
 	log "github.com/sirupsen/logrus"
 )
 
 const (
-	poeInfoRequest = "https://poe.game.qq.com/api/trade/fetch/%s?query=%s"
+	// MaxFetchIDs 单次fetch最多支持的物品数
+	MaxFetchIDs = 10
 )
 
+// ValidGoodID 物品ID由字母、数字、-和_组成，不能为空
+func ValidGoodID(id string) bool {
+	if id == "" {
+		return false
+	}
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
 type GetInfoRes struct {
 	Result []*PoeGood `json:"result"`
 }
 
 func (c *client) GetInfo(ctx context.Context, searchID string, goodID string) (*PoeGood, error) {
-	err := rateLimit.Wait(ctx)
-	if err != nil {
-		log.WithContext(ctx).Errorf("Rate limit fail, err: %v", err)
-		return nil, err
+	if !ValidGoodID(goodID) {
+		return nil, fmt.Errorf("%w: invalid good id %q", ErrBadRequest, goodID)
 	}
-	reqURL := fmt.Sprintf(poeInfoRequest, goodID, searchID)
-	rspBody, err := c.request(ctx, reqURL)
+	reqURL := c.realm.FetchURL(searchID, []string{goodID})
+	rspBody, err := c.request(ctx, endpointFetch, reqURL)
 	if err != nil {
 		log.WithContext(ctx).Errorf("Request fail, err: %v", err)
 		return nil, err
@@ -45,14 +56,50 @@ func (c *client) GetInfo(ctx context.Context, searchID string, goodID string) (*
 	return res.Result[0], err
 }
 
+// BatchGetInfo 批量获取物品详情，超过MaxFetchIDs时分批请求
+// 部分物品获取失败时只返回成功的部分，全部失败时才返回错误
 func (c *client) BatchGetInfo(ctx context.Context, searchID string, goodIDs []string) ([]*PoeGood, error) {
-	err := rateLimit.Wait(ctx)
-	if err != nil {
-		log.WithContext(ctx).Errorf("Rate limit fail, err: %v", err)
-		return nil, err
+	validIDs := make([]string, 0, len(goodIDs))
+	for _, id := range goodIDs {
+		if !ValidGoodID(id) {
+			log.WithContext(ctx).Warnf("Skip invalid good id %q", id)
+			continue
+		}
+		validIDs = append(validIDs, id)
 	}
-	reqURL := fmt.Sprintf(poeInfoRequest, strings.Join(goodIDs, ","), searchID)
-	rspBody, err := c.request(ctx, reqURL)
+	if len(validIDs) == 0 {
+		return nil, fmt.Errorf("%w: no valid good id", ErrBadRequest)
+	}
+	goodIDs = validIDs
+
+	res := make([]*PoeGood, 0, len(goodIDs))
+	var lastErr error
+	for begin := 0; begin < len(goodIDs); begin += MaxFetchIDs {
+		end := begin + MaxFetchIDs
+		if end > len(goodIDs) {
+			end = len(goodIDs)
+		}
+		goods, err := c.fetch(ctx, searchID, goodIDs[begin:end])
+		if err != nil {
+			log.WithContext(ctx).Errorf("fetch %v fail, err: %v", goodIDs[begin:end], err)
+			lastErr = err
+			continue
+		}
+		res = append(res, goods...)
+	}
+	if len(res) == 0 {
+		if lastErr == nil {
+			lastErr = fmt.Errorf("empty result")
+		}
+		log.WithContext(ctx).Errorf("BatchGetInfo fail, err: %v", lastErr)
+		return nil, lastErr
+	}
+	return res, nil
+}
+
+func (c *client) fetch(ctx context.Context, searchID string, goodIDs []string) ([]*PoeGood, error) {
+	reqURL := c.realm.FetchURL(searchID, goodIDs)
+	rspBody, err := c.request(ctx, endpointFetch, reqURL)
 	if err != nil {
 		log.WithContext(ctx).Errorf("Request fail, err: %v", err)
 		return nil, err
@@ -63,9 +110,16 @@ func (c *client) BatchGetInfo(ctx context.Context, searchID string, goodIDs []st
 		log.WithContext(ctx).Errorf("Unmarshal fail, err: %v", err)
 		return nil, err
 	}
-	if len(res.Result) == 0 {
-		log.WithContext(ctx).Errorf("Empty result")
-		return nil, fmt.Errorf("empty result")
+	// 已下架的物品返回null
+	goods := make([]*PoeGood, 0, len(res.Result))
+	for _, good := range res.Result {
+		if good == nil {
+			continue
+		}
+		goods = append(goods, good)
+	}
+	if len(goods) < len(goodIDs) {
+		log.WithContext(ctx).Warnf("fetch %d goods, got %d", len(goodIDs), len(goods))
 	}
-	return res.Result, err
+	return goods, nil
 }
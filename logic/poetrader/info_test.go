@@ -0,0 +1,178 @@
+package poetrader_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ink19/poewatcher/logic/poetrader"
+	"github.com/ink19/poewatcher/logic/poetrader/poetradertest"
+)
+
+func TestGetInfo(t *testing.T) {
+	srv := poetradertest.NewServer()
+	defer srv.Close()
+	srv.AddGoods(poetradertest.NewGood("a", "item a"))
+	c := newTestClient(t, srv)
+
+	good, err := c.GetInfo(context.Background(), "abc", "a")
+	if err != nil {
+		t.Fatalf("GetInfo fail, err: %v", err)
+	}
+	if good.ID != "a" || good.Item.Extended.DescText == "" {
+		t.Errorf("good = %+v", good)
+	}
+}
+
+func TestGetInfoErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		rsp  poetradertest.Response
+		want error
+	}{
+		{"unauthorized", poetradertest.Status(401), poetrader.ErrUnauthorized},
+		{"forbidden", poetradertest.Status(403), poetrader.ErrForbidden},
+		{"server", poetradertest.Status(502), poetrader.ErrServer},
+		{"html", poetradertest.HTMLPage(), poetrader.ErrInvalidResponse},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := poetradertest.NewServer()
+			defer srv.Close()
+			srv.Enqueue(poetradertest.EndpointFetch, tt.rsp)
+			c := newTestClient(t, srv)
+
+			_, err := c.GetInfo(context.Background(), "abc", "a")
+			if !errors.Is(err, tt.want) {
+				t.Errorf("GetInfo err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetInfoMalformed(t *testing.T) {
+	srv := poetradertest.NewServer()
+	defer srv.Close()
+	srv.Enqueue(poetradertest.EndpointFetch, poetradertest.Malformed())
+	c := newTestClient(t, srv)
+
+	if _, err := c.GetInfo(context.Background(), "abc", "a"); err == nil {
+		t.Errorf("GetInfo err = nil, want unmarshal error")
+	}
+}
+
+func TestGetInfoRateLimited(t *testing.T) {
+	srv := poetradertest.NewServer()
+	defer srv.Close()
+	srv.Enqueue(poetradertest.EndpointFetch, poetradertest.RateLimited(1))
+	srv.AddGoods(poetradertest.NewGood("a", "item a"))
+	c := newTestClient(t, srv)
+
+	_, err := c.GetInfo(context.Background(), "abc", "a")
+	if !errors.Is(err, poetrader.ErrRateLimited) {
+		t.Fatalf("GetInfo err = %v, want ErrRateLimited", err)
+	}
+	if d := poetrader.RetryAfter(err); d != time.Second {
+		t.Errorf("RetryAfter = %s, want 1s", d)
+	}
+
+	// 下一次请求等到限频解除后才发出
+	begin := time.Now()
+	if _, err := c.GetInfo(context.Background(), "abc", "a"); err != nil {
+		t.Fatalf("GetInfo fail, err: %v", err)
+	}
+	if d := time.Since(begin); d < 900*time.Millisecond {
+		t.Errorf("second request sent after %s, want at least 1s", d)
+	}
+}
+
+func TestBatchGetInfoChunks(t *testing.T) {
+	srv := poetradertest.NewServer()
+	defer srv.Close()
+	ids := []string{}
+	for i := 0; i < 25; i++ {
+		id := fmt.Sprintf("id%d", i)
+		ids = append(ids, id)
+		// 已下架的物品返回null
+		if i%5 != 0 {
+			srv.AddGoods(poetradertest.NewGood(id, id))
+		}
+	}
+	c := newTestClient(t, srv)
+
+	goods, err := c.BatchGetInfo(context.Background(), "abc", ids)
+	if err != nil {
+		t.Fatalf("BatchGetInfo fail, err: %v", err)
+	}
+	if len(goods) != 20 {
+		t.Errorf("got %d goods, want 20", len(goods))
+	}
+	sizes := []int{}
+	for _, f := range srv.Fetches() {
+		sizes = append(sizes, len(f))
+	}
+	if want := []int{10, 10, 5}; !reflect.DeepEqual(sizes, want) {
+		t.Errorf("fetch sizes = %v, want %v", sizes, want)
+	}
+}
+
+func TestBatchGetInfoPartialFailure(t *testing.T) {
+	srv := poetradertest.NewServer()
+	defer srv.Close()
+	ids := []string{}
+	for i := 0; i < 15; i++ {
+		id := fmt.Sprintf("id%d", i)
+		ids = append(ids, id)
+		srv.AddGoods(poetradertest.NewGood(id, id))
+	}
+	srv.Enqueue(poetradertest.EndpointFetch, poetradertest.Status(500))
+	c := newTestClient(t, srv)
+
+	goods, err := c.BatchGetInfo(context.Background(), "abc", ids)
+	if err != nil {
+		t.Fatalf("BatchGetInfo fail, err: %v", err)
+	}
+	if len(goods) != 5 || goods[0].ID != "id10" {
+		t.Errorf("got %d goods, want the last 5", len(goods))
+	}
+}
+
+func TestBatchGetInfoAllFailed(t *testing.T) {
+	srv := poetradertest.NewServer()
+	defer srv.Close()
+	srv.Enqueue(poetradertest.EndpointFetch, poetradertest.Status(401))
+	c := newTestClient(t, srv)
+
+	_, err := c.BatchGetInfo(context.Background(), "abc", []string{"a"})
+	if !errors.Is(err, poetrader.ErrUnauthorized) {
+		t.Errorf("BatchGetInfo err = %v, want ErrUnauthorized", err)
+	}
+}
+
+func TestBatchGetInfoSkipsInvalidIDs(t *testing.T) {
+	srv := poetradertest.NewServer()
+	defer srv.Close()
+	srv.AddGoods(poetradertest.NewGood("a", "item a"))
+	c := newTestClient(t, srv)
+
+	goods, err := c.BatchGetInfo(context.Background(), "abc", []string{"", "a", "b/c"})
+	if err != nil {
+		t.Fatalf("BatchGetInfo fail, err: %v", err)
+	}
+	if len(goods) != 1 || goods[0].ID != "a" {
+		t.Errorf("goods = %v", goods)
+	}
+	if fetches := srv.Fetches(); !reflect.DeepEqual(fetches, [][]string{{"a"}}) {
+		t.Errorf("fetches = %v, want [[a]]", fetches)
+	}
+
+	if _, err := c.GetInfo(context.Background(), "abc", ""); !errors.Is(err, poetrader.ErrBadRequest) {
+		t.Errorf("GetInfo err = %v, want ErrBadRequest", err)
+	}
+	if n := srv.Requests(poetradertest.EndpointFetch); n != 1 {
+		t.Errorf("fetch requests = %d, want 1", n)
+	}
+}
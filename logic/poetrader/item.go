@@ -0,0 +1,175 @@
+package poetrader
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+type PoeGood struct {
+	ID      string     `json:"id"`
+	Listing PoeListing `json:"listing"`
+	Item    PoeItem    `json:"item"`
+}
+
+type PoeListing struct {
+	Method       string      `json:"method"`
+	Indexed      time.Time   `json:"indexed"`
+	Stash        *PoeStash   `json:"stash,omitempty"`
+	Whisper      string      `json:"whisper"`
+	WhisperToken string      `json:"whisper_token"`
+	Account      *PoeAccount `json:"account,omitempty"`
+	Price        *PoePrice   `json:"price,omitempty"`
+}
+
+type PoeStash struct {
+	Name string `json:"name"`
+	X    int    `json:"x"`
+	Y    int    `json:"y"`
+}
+
+type PoeAccount struct {
+	Name              string     `json:"name"`
+	LastCharacterName string     `json:"lastCharacterName"`
+	Online            *PoeOnline `json:"online,omitempty"`
+	Language          string     `json:"language"`
+	Realm             string     `json:"realm"`
+}
+
+// PoeOnline 离线时接口返回null
+type PoeOnline struct {
+	League string `json:"league"`
+	Status string `json:"status,omitempty"`
+}
+
+type PoePrice struct {
+	Type     string  `json:"type"`
+	Amount   float64 `json:"amount"`
+	Currency string  `json:"currency"`
+}
+
+type PoeItemExtended struct {
+	DescText string `json:"text"`
+}
+
+type PoeSocket struct {
+	Group  int    `json:"group"`
+	Attr   string `json:"attr"`
+	Colour string `json:"sColour"`
+}
+
+// PoePropertyValue 接口中格式为 ["+20%", 1]
+type PoePropertyValue struct {
+	Value string
+	Type  int
+}
+
+func (v *PoePropertyValue) UnmarshalJSON(data []byte) error {
+	raw := []json.RawMessage{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if len(raw) != 2 {
+		return fmt.Errorf("invalid property value: %s", string(data))
+	}
+	if err := json.Unmarshal(raw[0], &v.Value); err != nil {
+		return err
+	}
+	return json.Unmarshal(raw[1], &v.Type)
+}
+
+func (v PoePropertyValue) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]interface{}{v.Value, v.Type})
+}
+
+type PoeProperty struct {
+	Name        string             `json:"name"`
+	Values      []PoePropertyValue `json:"values"`
+	DisplayMode int                `json:"displayMode"`
+	Type        int                `json:"type,omitempty"`
+}
+
+type PoeItem struct {
+	Verified   bool   `json:"verified"`
+	Width      int    `json:"w"`
+	Height     int    `json:"h"`
+	Icon       string `json:"icon"`
+	League     string `json:"league"`
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	TypeLine   string `json:"typeLine"`
+	BaseType   string `json:"baseType"`
+	Rarity     string `json:"rarity"`
+	FrameType  int    `json:"frameType"`
+	Identified bool   `json:"identified"`
+	ItemLevel  int    `json:"ilvl"`
+	StackSize  int    `json:"stackSize,omitempty"`
+	Note       string `json:"note,omitempty"`
+
+	Sockets      []PoeSocket   `json:"sockets,omitempty"`
+	Properties   []PoeProperty `json:"properties,omitempty"`
+	Requirements []PoeProperty `json:"requirements,omitempty"`
+
+	ImplicitMods  []string `json:"implicitMods,omitempty"`
+	ExplicitMods  []string `json:"explicitMods,omitempty"`
+	CraftedMods   []string `json:"craftedMods,omitempty"`
+	EnchantMods   []string `json:"enchantMods,omitempty"`
+	FracturedMods []string `json:"fracturedMods,omitempty"`
+
+	Influences map[string]bool `json:"influences,omitempty"`
+	Corrupted  bool            `json:"corrupted,omitempty"`
+	// 镜像物品接口中为duplicated
+	Mirrored bool `json:"duplicated,omitempty"`
+
+	Extended PoeItemExtended `json:"extended"`
+}
+
+// Links 返回最大连接数
+func (i *PoeItem) Links() int {
+	groups := map[int]int{}
+	links := 0
+	for _, s := range i.Sockets {
+		groups[s.Group]++
+		if groups[s.Group] > links {
+			links = groups[s.Group]
+		}
+	}
+	return links
+}
+
+// SocketString 返回形如 "R-G-B B" 的孔描述
+func (i *PoeItem) SocketString() string {
+	sb := strings.Builder{}
+	for idx, s := range i.Sockets {
+		if idx > 0 {
+			if s.Group == i.Sockets[idx-1].Group {
+				sb.WriteString("-")
+			} else {
+				sb.WriteString(" ")
+			}
+		}
+		sb.WriteString(s.Colour)
+	}
+	return sb.String()
+}
+
+// FullName 返回名字和类型，普通物品没有名字
+func (i *PoeItem) FullName() string {
+	if i.Name == "" {
+		return i.TypeLine
+	}
+	return i.Name + " " + i.TypeLine
+}
+
+func (i *PoeItem) InfluenceList() []string {
+	res := []string{}
+	for k, v := range i.Influences {
+		if v {
+			res = append(res, k)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
@@ -4,18 +4,70 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"math/rand"
+	"net"
 	"net/http"
+	"time"
 
+	"github.com/ink19/poewatcher/config"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/gorilla/websocket"
 )
 
-const watchURLFormat = "wss://poe.game.qq.com/api/trade/live/%s/%s"
+const (
+	defaultReconnectMinDelay = time.Second
+	defaultReconnectMaxDelay = 5 * time.Minute
+
+	defaultPingInterval = 30 * time.Second
+	writeWait           = 10 * time.Second
+
+	// maxPendingGoods 调用方处理不过来时缓存的物品数，超过后丢弃
+	maxPendingGoods = 1000
+)
+
+func pingInterval() time.Duration {
+	if v := config.Get().Poe.PingInterval; v > 0 {
+		return time.Duration(v) * time.Second
+	}
+	return defaultPingInterval
+}
+
+// pongWait 允许丢失一次pong
+func pongWait() time.Duration {
+	return 2*pingInterval() + writeWait
+}
+
+func idleTimeout() time.Duration {
+	return time.Duration(config.Get().Poe.IdleTimeout) * time.Minute
+}
+
+// wsConn 直播搜索连接，录制和回放时替换为对应的实现
+type wsConn interface {
+	ReadMessage() (int, []byte, error)
+	WriteMessage(messageType int, data []byte) error
+	WriteControl(messageType int, data []byte, deadline time.Time) error
+	SetReadDeadline(t time.Time) error
+	SetPongHandler(h func(appData string) error)
+	Close() error
+}
+
+type wsDialFunc func(ctx context.Context, url string, header http.Header) (wsConn, *http.Response, error)
+
+func (c *client) dialWebsocket(ctx context.Context, url string, header http.Header) (wsConn, *http.Response, error) {
+	conn, rsp, err := c.wsDialer.DialContext(ctx, url, header)
+	if err != nil {
+		return nil, rsp, err
+	}
+	return conn, rsp, nil
+}
 
 type wsMessage struct {
 	messageType int
 	message string
+	// err 读取失败时设置，之后不会再有消息
+	err error
 }
 
 type wsRecvMsg struct {
@@ -23,109 +75,320 @@ type wsRecvMsg struct {
 	New []string `json:"new,omitempty"`
 }
 
+// Watch 第一次连接遇到致命错误时直接返回，其他错误和断线一样在后台退避重连
 func (c *client) Watch(ctx context.Context, searchID string) (<-chan *PoeGood, error) {
 	log.WithContext(ctx).Debugf("Enter Watch")
+	c.setErr(nil)
+	attempt := 0
+	var delay time.Duration
+	conn, err := c.dialWatch(ctx, searchID)
+	if err != nil {
+		if IsFatal(err) {
+			log.WithContext(ctx).Errorf("dialWatch fail, err: %v", err)
+			return nil, err
+		}
+		// 返回前调用重连回调，调用方可以知道还没有连上
+		var ok bool
+		if delay, ok = c.backoff(ctx, searchID, &attempt, err); !ok {
+			return nil, err
+		}
+	}
+
 	ch := make(chan *PoeGood)
-	watchURL := fmt.Sprintf(watchURLFormat, c.seasonID, searchID)
+	c.wg.Add(1)
+	go func ()  {
+		defer c.wg.Done()
+		defer close(ch)
+
+		for {
+			if conn == nil {
+				var stopped bool
+				conn, stopped, err = c.redialWatch(ctx, searchID, delay)
+				if stopped {
+					return
+				}
+				if err != nil {
+					if IsFatal(err) {
+						log.WithContext(ctx).Errorf("Watch %s stop, err: %v", searchID, err)
+						c.setErr(err)
+						return
+					}
+					var ok bool
+					if delay, ok = c.backoff(ctx, searchID, &attempt, err); !ok {
+						c.setErr(err)
+						return
+					}
+					continue
+				}
+				if c.onReconnected != nil {
+					c.onReconnected(ctx, attempt)
+				}
+			}
+
+			live, err := c.serveWatch(ctx, conn, ch)
+			if err == nil {
+				return
+			}
+			if IsFatal(err) {
+				log.WithContext(ctx).Errorf("Watch %s stop, err: %v", searchID, err)
+				c.setErr(err)
+				return
+			}
+			if live {
+				attempt = 0
+			}
+			conn = nil
+			var ok bool
+			if delay, ok = c.backoff(ctx, searchID, &attempt, err); !ok {
+				c.setErr(err)
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// backoff 记录一次重连并返回需要等待的时间，超过最大次数时返回false
+func (c *client) backoff(ctx context.Context, searchID string, attempt *int, err error) (time.Duration, bool) {
+	*attempt++
+	if maxAttempts := config.Get().Poe.Reconnect.MaxAttempts; maxAttempts > 0 && *attempt > maxAttempts {
+		log.WithContext(ctx).Errorf("Watch %s reconnect %d times, give up, err: %v", searchID, maxAttempts, err)
+		return 0, false
+	}
+	delay := reconnectDelay(*attempt)
+	if retryAfter := RetryAfter(err); retryAfter > delay {
+		delay = retryAfter
+	}
+	log.WithContext(ctx).Warnf("Watch %s disconnected, reconnect attempt %d after %s, err: %v", searchID, *attempt, delay, err)
+	if c.onReconnect != nil {
+		c.onReconnect(ctx, *attempt, delay, err)
+	}
+	return delay, true
+}
+
+// redialWatch 等待delay后重新连接，等待时被停止返回stopped
+func (c *client) redialWatch(ctx context.Context, searchID string, delay time.Duration) (conn wsConn, stopped bool, err error) {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		return nil, true, nil
+	case <-c.stopChan:
+		return nil, true, nil
+	}
+	conn, err = c.dialWatch(ctx, searchID)
+	return conn, false, err
+}
+
+func (c *client) dialWatch(ctx context.Context, searchID string) (wsConn, error) {
+	watchURL := c.realm.LiveURL(c.seasonID, searchID)
 	header := &http.Header{}
 	if c.header != nil {
 		header = c.header
 	}
 	log.WithContext(ctx).Debugf("Watch url: %s", watchURL)
-	conn, rsp, err := websocket.DefaultDialer.DialContext(ctx, watchURL, *header)
+	conn, rsp, err := c.dialWS(ctx, watchURL, *header)
 	if err != nil {
+		if rsp == nil {
+			log.WithContext(ctx).Errorf("WS connect fail, err: %v", err)
+			return nil, err
+		}
+		defer rsp.Body.Close()
+		body, _ := io.ReadAll(rsp.Body)
 		log.WithContext(ctx).Errorf("WS connect fail, err: %v, rsp code: %d", err, rsp.StatusCode)
+		if statusErr := newStatusError(rsp, body); statusErr != nil {
+			return nil, statusErr
+		}
 		return nil, err
 	}
+	return conn, nil
+}
+
+// serveWatch 处理一条连接上的消息，直到连接断开或者被停止
+// 被停止时返回nil，live表示连接是否曾经鉴权成功
+// 调用方阻塞时物品先缓存在pending中，继续读取连接，避免pong处理不及时被误判为连接失效
+func (c *client) serveWatch(ctx context.Context, conn wsConn, ch chan<- *PoeGood) (live bool, err error) {
 	log.WithContext(ctx).Debugf("BeginWatch")
-	msgChan, err := c.readWSConn(ctx, conn)
-	if err != nil {
-		log.WithContext(ctx).Errorf("ReadWsConn fail, err: %v", err)
-		return nil, err
+	msgChan := c.readWSConn(ctx, conn)
+	var pending []*PoeGood
+	defer func() {
+		conn.Close()
+		for remainMsg := range msgChan {
+			log.WithContext(ctx).Debugf("Drop msg: %s", remainMsg.message)
+		}
+		// 断线重连前把已经收到的物品交给调用方
+		if err != nil {
+			c.sendPending(ctx, ch, pending)
+		}
+	}()
+
+	pingTicker := time.NewTicker(pingInterval())
+	defer pingTicker.Stop()
+	// 长时间没有收到消息时主动重连，为0时不检查
+	var (
+		idleTimer *time.Timer
+		idleC     <-chan time.Time
+	)
+	idle := idleTimeout()
+	if idle > 0 {
+		idleTimer = time.NewTimer(idle)
+		defer idleTimer.Stop()
+		idleC = idleTimer.C
 	}
-	c.wg.Add(1)
-	go func ()  {
-		defer c.wg.Done()
-		for {
-			hasDone := false
-			select {
-			case <- ctx.Done():
-				hasDone = true
-			case msg := <- msgChan:
-				if msg == nil {
-					break
-				}
-				log.WithContext(ctx).Debugf("Recv msg: %s", msg.message)
-				recvMsg := &wsRecvMsg{}
-				err := json.Unmarshal([]byte(msg.message), recvMsg)
-				if err != nil {
-					log.WithContext(ctx).Errorf("Unmarshal fail, err: %v", err)
-					break
-				}
-				if recvMsg.Auth != nil {
-					if !*recvMsg.Auth {
-						log.WithContext(ctx).Errorf("Auth fail")
-						hasDone = true
-					} else {
-						log.WithContext(ctx).Debugf("Auth succ")
-					}
-					break
+
+	for {
+		var (
+			out  chan<- *PoeGood
+			next *PoeGood
+		)
+		if len(pending) > 0 {
+			out, next = ch, pending[0]
+		}
+		select {
+		case out <- next:
+			pending = pending[1:]
+		case <- ctx.Done():
+			c.closeWSConn(ctx, conn)
+			return live, nil
+		case <- c.stopChan:
+			c.closeWSConn(ctx, conn)
+			return live, nil
+		case <- pingTicker.C:
+			err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait))
+			if err != nil {
+				log.WithContext(ctx).Errorf("Ping fail, err: %v", err)
+				return live, fmt.Errorf("ping fail: %w", err)
+			}
+		case <- idleC:
+			log.WithContext(ctx).Warnf("No message for %s, reconnect", idle)
+			c.closeWSConn(ctx, conn)
+			return live, fmt.Errorf("%w: no message for %s", ErrStaleConnection, idle)
+		case msg, ok := <- msgChan:
+			if !ok {
+				return live, fmt.Errorf("connection lost")
+			}
+			if msg.err != nil {
+				netErr, isNetErr := msg.err.(net.Error)
+				if isNetErr && netErr.Timeout() {
+					return live, fmt.Errorf("%w: %v", ErrStaleConnection, msg.err)
 				}
-				for _, goodID := range recvMsg.New {
-					ch <- &PoeGood{
-						ID: goodID,
-					}
+				return live, fmt.Errorf("connection lost: %w", msg.err)
+			}
+			if idleTimer != nil {
+				if !idleTimer.Stop() {
+					<-idleTimer.C
 				}
-			case <- c.stopChan:
-				hasDone = true
+				idleTimer.Reset(idle)
 			}
-
-			if hasDone {
-				// 关闭连接
-				err := conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
-				if err != nil {
-					log.WithContext(ctx).Errorf("WriteMessage fail, err: %v", err)
+			log.WithContext(ctx).Debugf("Recv msg: %s", msg.message)
+			recvMsg := &wsRecvMsg{}
+			err := json.Unmarshal([]byte(msg.message), recvMsg)
+			if err != nil {
+				log.WithContext(ctx).Errorf("Unmarshal fail, err: %v", err)
+				break
+			}
+			if recvMsg.Auth != nil {
+				if !*recvMsg.Auth {
+					log.WithContext(ctx).Errorf("Auth fail")
+					c.closeWSConn(ctx, conn)
+					return live, ErrUnauthorized
 				}
-				log.WithContext(ctx).Debugf("Ctx done")
-				conn.Close()
+				log.WithContext(ctx).Debugf("Auth succ")
+				live = true
 				break
 			}
+			for _, goodID := range recvMsg.New {
+				if !ValidGoodID(goodID) {
+					log.WithContext(ctx).Warnf("Drop invalid good id %q", goodID)
+					continue
+				}
+				if len(pending) >= maxPendingGoods {
+					log.WithContext(ctx).Warnf("Too many pending goods, drop %s", goodID)
+					continue
+				}
+				pending = append(pending, &PoeGood{ID: goodID})
+			}
 		}
+	}
+}
 
-		for remainMsg := range msgChan {
-			_ = remainMsg
-			log.WithContext(ctx).Debugf("Recv msg: %s", remainMsg.message)
-			ch <- &PoeGood{}
+// sendPending 连接断开后把缓存的物品交给调用方，被停止时丢弃
+func (c *client) sendPending(ctx context.Context, ch chan<- *PoeGood, pending []*PoeGood) {
+	for _, good := range pending {
+		select {
+		case ch <- good:
+		case <-ctx.Done():
+			return
+		case <-c.stopChan:
+			return
 		}
-	}()
-	return ch, nil
+	}
 }
 
-func (c *client) readWSConn(ctx context.Context, conn *websocket.Conn) (chan *wsMessage, error) {
+func (c *client) closeWSConn(ctx context.Context, conn wsConn) {
+	// 关闭连接
+	err := conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	if err != nil {
+		log.WithContext(ctx).Errorf("WriteMessage fail, err: %v", err)
+	}
+}
+
+func (c *client) readWSConn(ctx context.Context, conn wsConn) chan *wsMessage {
 	msgChan := make(chan *wsMessage, 10)
 	c.wg.Add(1)
 	go func ()  {
 		defer c.wg.Done()
 		defer close(msgChan)
 
+		// 超过pongWait没有收到任何数据（包括pong）时读超时，认为连接已失效
+		_ = conn.SetReadDeadline(time.Now().Add(pongWait()))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(pongWait()))
+		})
 		for {
 			mt, ms, err := conn.ReadMessage()
 			if err != nil {
 				log.WithContext(ctx).Errorf("ReadMessage fail, err: %v", err)
+				msgChan <- &wsMessage{err: err}
 				return
 			}
+			_ = conn.SetReadDeadline(time.Now().Add(pongWait()))
 			msgChan <- &wsMessage{
 				messageType: mt,
 				message: string(ms),
 			}
 		}
 	}()
-	return msgChan, nil
+	return msgChan
+}
+
+// reconnectDelay 指数退避，带±50%的随机抖动
+func reconnectDelay(attempt int) time.Duration {
+	minDelay := defaultReconnectMinDelay
+	if v := config.Get().Poe.Reconnect.MinDelay; v > 0 {
+		minDelay = time.Duration(v) * time.Second
+	}
+	maxDelay := defaultReconnectMaxDelay
+	if v := config.Get().Poe.Reconnect.MaxDelay; v > 0 {
+		maxDelay = time.Duration(v) * time.Second
+	}
+
+	delay := minDelay
+	for i := 1; i < attempt && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay)))
 }
 
 func (c *client) Stop(ctx context.Context) error {
-	c.stopChan <- struct{}{}
-	
+	c.stopOnce.Do(func() {
+		close(c.stopChan)
+	})
+
 	c.wg.Wait()
 	return nil
 }
@@ -0,0 +1,278 @@
+package poetrader_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ink19/poewatcher/logic/poetrader"
+	"github.com/ink19/poewatcher/logic/poetrader/poetradertest"
+)
+
+const testTimeout = 10 * time.Second
+
+// newTestClient 每个测试使用不同的cookie，避免共享账号限频状态
+func newTestClient(t *testing.T, srv *poetradertest.Server, opts ...poetrader.Option) poetrader.Client {
+	t.Helper()
+	opts = append([]poetrader.Option{poetrader.WithRealm(srv.Realm())}, opts...)
+	c := poetrader.New("Standard", "POESESSID="+t.Name(), opts...)
+	t.Cleanup(func() {
+		_ = c.Stop(context.Background())
+	})
+	return c
+}
+
+func nextLive(t *testing.T, srv *poetradertest.Server) *poetradertest.LiveConn {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	conn, err := srv.NextLive(ctx)
+	if err != nil {
+		t.Fatalf("wait live connection fail, err: %v", err)
+	}
+	return conn
+}
+
+func recvGood(t *testing.T, ch <-chan *poetrader.PoeGood) *poetrader.PoeGood {
+	t.Helper()
+	select {
+	case good, ok := <-ch:
+		if !ok {
+			t.Fatalf("watch channel closed")
+		}
+		return good
+	case <-time.After(testTimeout):
+		t.Fatalf("wait good timeout")
+	}
+	return nil
+}
+
+func waitClosed(t *testing.T, ch <-chan *poetrader.PoeGood) {
+	t.Helper()
+	for {
+		select {
+		case _, ok := <-ch:
+			if !ok {
+				return
+			}
+		case <-time.After(testTimeout):
+			t.Fatalf("wait watch channel closed timeout")
+		}
+	}
+}
+
+func TestWatchPushesNewIDs(t *testing.T) {
+	srv := poetradertest.NewServer()
+	defer srv.Close()
+	c := newTestClient(t, srv)
+
+	ch, err := c.Watch(context.Background(), "abc")
+	if err != nil {
+		t.Fatalf("Watch fail, err: %v", err)
+	}
+	conn := nextLive(t, srv)
+	if conn.SearchID != "abc" {
+		t.Errorf("search id = %s, want abc", conn.SearchID)
+	}
+	if got := conn.Header.Get("Cookie"); got != "POESESSID="+t.Name() {
+		t.Errorf("cookie = %s", got)
+	}
+	if err := conn.Push("a", "b"); err != nil {
+		t.Fatalf("Push fail, err: %v", err)
+	}
+	for _, want := range []string{"a", "b"} {
+		if good := recvGood(t, ch); good.ID != want {
+			t.Errorf("good id = %s, want %s", good.ID, want)
+		}
+	}
+
+	if err := c.Stop(context.Background()); err != nil {
+		t.Fatalf("Stop fail, err: %v", err)
+	}
+	waitClosed(t, ch)
+	if err := c.Err(); err != nil {
+		t.Errorf("Err() = %v, want nil after Stop", err)
+	}
+}
+
+func TestWatchKeepsPendingGoodsAcrossDisconnect(t *testing.T) {
+	srv := poetradertest.NewServer()
+	defer srv.Close()
+	c := newTestClient(t, srv)
+
+	ch, err := c.Watch(context.Background(), "abc")
+	if err != nil {
+		t.Fatalf("Watch fail, err: %v", err)
+	}
+	// 调用方暂时不读取，连接继续接收消息，断开后依次交给调用方
+	conn := nextLive(t, srv)
+	ids := []string{}
+	for i := 0; i < 30; i++ {
+		id := fmt.Sprintf("p%d", i)
+		ids = append(ids, id)
+		if err := conn.Push(id); err != nil {
+			t.Fatalf("Push fail, err: %v", err)
+		}
+	}
+	time.Sleep(200 * time.Millisecond)
+	conn.Disconnect()
+	for _, want := range ids {
+		if good := recvGood(t, ch); good.ID != want {
+			t.Fatalf("good id = %s, want %s", good.ID, want)
+		}
+	}
+}
+
+func TestWatchIgnoresMalformedMessage(t *testing.T) {
+	srv := poetradertest.NewServer()
+	defer srv.Close()
+	c := newTestClient(t, srv)
+
+	ch, err := c.Watch(context.Background(), "abc")
+	if err != nil {
+		t.Fatalf("Watch fail, err: %v", err)
+	}
+	conn := nextLive(t, srv)
+	if err := conn.Send(`{"new": [`); err != nil {
+		t.Fatalf("Send fail, err: %v", err)
+	}
+	// 空的和不合法的ID不会交给调用方
+	if err := conn.Push("", "x,y", "c"); err != nil {
+		t.Fatalf("Push fail, err: %v", err)
+	}
+	if good := recvGood(t, ch); good.ID != "c" {
+		t.Errorf("good id = %s, want c", good.ID)
+	}
+}
+
+func TestWatchAuthFail(t *testing.T) {
+	srv := poetradertest.NewServer()
+	defer srv.Close()
+	srv.SetLiveAuth("abc", false)
+	c := newTestClient(t, srv)
+
+	ch, err := c.Watch(context.Background(), "abc")
+	if err != nil {
+		t.Fatalf("Watch fail, err: %v", err)
+	}
+	waitClosed(t, ch)
+	if err := c.Err(); !errors.Is(err, poetrader.ErrUnauthorized) {
+		t.Errorf("Err() = %v, want ErrUnauthorized", err)
+	}
+}
+
+func TestWatchHandshakeNotFound(t *testing.T) {
+	srv := poetradertest.NewServer()
+	defer srv.Close()
+	srv.Enqueue(poetradertest.EndpointLive, poetradertest.Status(404))
+	c := newTestClient(t, srv)
+
+	_, err := c.Watch(context.Background(), "abc")
+	if !errors.Is(err, poetrader.ErrNotFound) {
+		t.Fatalf("Watch err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestWatchRetriesTemporaryHandshakeError(t *testing.T) {
+	srv := poetradertest.NewServer()
+	defer srv.Close()
+	srv.Enqueue(poetradertest.EndpointLive, poetradertest.Status(503))
+
+	var (
+		lock        sync.Mutex
+		attempts    []int
+		reconnected []int
+	)
+	c := newTestClient(t, srv, poetrader.WithReconnectHandler(func(ctx context.Context, attempt int, delay time.Duration, err error) {
+		lock.Lock()
+		defer lock.Unlock()
+		attempts = append(attempts, attempt)
+	}), poetrader.WithReconnectedHandler(func(ctx context.Context, attempt int) {
+		lock.Lock()
+		defer lock.Unlock()
+		reconnected = append(reconnected, attempt)
+	}))
+
+	ch, err := c.Watch(context.Background(), "abc")
+	if err != nil {
+		t.Fatalf("Watch fail, err: %v", err)
+	}
+	// 重连回调在Watch返回前调用
+	lock.Lock()
+	if len(attempts) != 1 || attempts[0] != 1 {
+		t.Errorf("reconnect attempts = %v, want [1]", attempts)
+	}
+	lock.Unlock()
+
+	conn := nextLive(t, srv)
+	if err := conn.Push("e"); err != nil {
+		t.Fatalf("Push fail, err: %v", err)
+	}
+	if good := recvGood(t, ch); good.ID != "e" {
+		t.Errorf("good id = %s, want e", good.ID)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if len(reconnected) != 1 || reconnected[0] != 1 {
+		t.Errorf("reconnected = %v, want [1]", reconnected)
+	}
+}
+
+func TestWatchReconnectAfterDisconnect(t *testing.T) {
+	srv := poetradertest.NewServer()
+	defer srv.Close()
+
+	var (
+		lock     sync.Mutex
+		attempts []int
+	)
+	c := newTestClient(t, srv, poetrader.WithReconnectHandler(func(ctx context.Context, attempt int, delay time.Duration, err error) {
+		lock.Lock()
+		defer lock.Unlock()
+		attempts = append(attempts, attempt)
+	}))
+
+	ch, err := c.Watch(context.Background(), "abc")
+	if err != nil {
+		t.Fatalf("Watch fail, err: %v", err)
+	}
+	nextLive(t, srv).Disconnect()
+
+	conn := nextLive(t, srv)
+	if err := conn.Push("d"); err != nil {
+		t.Fatalf("Push fail, err: %v", err)
+	}
+	if good := recvGood(t, ch); good.ID != "d" {
+		t.Errorf("good id = %s, want d", good.ID)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if len(attempts) != 1 || attempts[0] != 1 {
+		t.Errorf("reconnect attempts = %v, want [1]", attempts)
+	}
+}
+
+func TestWatchStopsOnFatalReconnect(t *testing.T) {
+	srv := poetradertest.NewServer()
+	defer srv.Close()
+	c := newTestClient(t, srv)
+
+	ch, err := c.Watch(context.Background(), "abc")
+	if err != nil {
+		t.Fatalf("Watch fail, err: %v", err)
+	}
+	// 断开后搜索已过期，不再重连
+	srv.Enqueue(poetradertest.EndpointLive, poetradertest.Status(404))
+	nextLive(t, srv).Close()
+
+	waitClosed(t, ch)
+	if err := c.Err(); !errors.Is(err, poetrader.ErrNotFound) {
+		t.Errorf("Err() = %v, want ErrNotFound", err)
+	}
+	if n := srv.Requests(poetradertest.EndpointLive); n != 2 {
+		t.Errorf("live requests = %d, want 2", n)
+	}
+}
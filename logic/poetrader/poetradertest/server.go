@@ -0,0 +1,393 @@
+// Package poetradertest 提供进程内的假交易站点，用于测试poetrader和watch
+//
+// 支持fetch、search、账号名和直播搜索接口，可以预设响应（限频、错误码、格式错误），
+// 直播搜索可以指定鉴权结果，并在测试中推送新物品、发送任意消息或者断开连接
+package poetradertest
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	"github.com/ink19/poewatcher/config"
+	"github.com/ink19/poewatcher/logic/poetrader"
+)
+
+const (
+	EndpointFetch   = "fetch"
+	EndpointSearch  = "search"
+	EndpointLive    = "live"
+	EndpointAccount = "account"
+)
+
+const apiPath = "/api/trade"
+
+// Response 预设的响应，Status为0时按200处理
+type Response struct {
+	Status int
+	Header http.Header
+	Body   string
+}
+
+// RateLimited 429，带有账号规则的限频头，retryAfter单位为秒
+func RateLimited(retryAfter int) Response {
+	header := http.Header{}
+	header.Set("X-Rate-Limit-Policy", "trade-test-request-limit")
+	header.Set("X-Rate-Limit-Rules", "Account")
+	// 窗口长度和惩罚时间都设为retryAfter，限频在retryAfter后解除
+	header.Set("X-Rate-Limit-Account", fmt.Sprintf("8:%d:%d", retryAfter, retryAfter))
+	header.Set("X-Rate-Limit-Account-State", fmt.Sprintf("9:%d:%d", retryAfter, retryAfter))
+	header.Set("Retry-After", strconv.Itoa(retryAfter))
+	return Response{
+		Status: http.StatusTooManyRequests,
+		Header: header,
+		Body:   `{"error":{"code":3,"message":"Rate limit exceeded"}}`,
+	}
+}
+
+// Status 返回带有错误信息的状态码
+func Status(code int) Response {
+	return Response{
+		Status: code,
+		Body:   fmt.Sprintf(`{"error":{"code":%d,"message":%q}}`, code, http.StatusText(code)),
+	}
+}
+
+// Malformed 状态码正常但是JSON不完整
+func Malformed() Response {
+	return Response{Body: `{"result":[{"id":`}
+}
+
+// HTMLPage 维护或者验证页面
+func HTMLPage() Response {
+	header := http.Header{}
+	header.Set("Content-Type", "text/html; charset=utf-8")
+	return Response{Header: header, Body: "<html><body>maintenance</body></html>"}
+}
+
+// NewGood 生成物品，DescText为desc的base64编码
+func NewGood(id string, desc string) *poetrader.PoeGood {
+	good := &poetrader.PoeGood{ID: id}
+	good.Item.Extended.DescText = base64.StdEncoding.EncodeToString([]byte(desc))
+	return good
+}
+
+type Server struct {
+	srv      *httptest.Server
+	upgrader websocket.Upgrader
+
+	lock         sync.Mutex
+	goods        map[string]*poetrader.PoeGood
+	searchID     string
+	searchResult []string
+	accountName  string
+	liveAuth     map[string]bool
+	scripts      map[string][]Response
+	requests     map[string]int
+	fetches      [][]string
+	conns        []*LiveConn
+	liveConns    chan *LiveConn
+}
+
+func NewServer() *Server {
+	s := &Server{
+		goods:       map[string]*poetrader.PoeGood{},
+		searchID:    "test-search",
+		accountName: "tester",
+		liveAuth:    map[string]bool{},
+		scripts:     map[string][]Response{},
+		requests:    map[string]int{},
+		liveConns:   make(chan *LiveConn, 64),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(apiPath+"/fetch/", s.handleFetch)
+	mux.HandleFunc(apiPath+"/search/", s.handleSearch)
+	mux.HandleFunc(apiPath+"/live/", s.handleLive)
+	mux.HandleFunc("/character-window/get-account-name", s.handleAccount)
+	s.srv = httptest.NewServer(mux)
+	return s
+}
+
+// Close 断开所有直播连接并关闭服务
+func (s *Server) Close() {
+	s.lock.Lock()
+	conns := s.conns
+	s.lock.Unlock()
+	for _, c := range conns {
+		c.Disconnect()
+	}
+	s.srv.Close()
+}
+
+func (s *Server) URL() string {
+	return s.srv.URL
+}
+
+// Realm 指向假站点的交易站点
+func (s *Server) Realm() *poetrader.Realm {
+	r := s.ConfigRealm()
+	return &poetrader.Realm{Name: "test", HTTPBase: r.HTTPBase, WSBase: r.WSBase}
+}
+
+// ConfigRealm 用于注册到config.Get().Poe.Realms
+func (s *Server) ConfigRealm() config.Realm {
+	return config.Realm{
+		HTTPBase: s.srv.URL + apiPath,
+		WSBase:   "ws" + strings.TrimPrefix(s.srv.URL, "http") + apiPath,
+	}
+}
+
+// AddGoods fetch返回的物品，没有添加的ID返回null
+func (s *Server) AddGoods(goods ...*poetrader.PoeGood) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, g := range goods {
+		s.goods[g.ID] = g
+	}
+}
+
+// SetSearch 设置search返回的搜索ID和结果
+func (s *Server) SetSearch(searchID string, result ...string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.searchID = searchID
+	s.searchResult = result
+}
+
+// SetAccountName 为空时表示cookie失效
+func (s *Server) SetAccountName(name string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.accountName = name
+}
+
+// SetLiveAuth 设置直播搜索连接后返回的鉴权结果，默认成功
+func (s *Server) SetLiveAuth(searchID string, ok bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.liveAuth[searchID] = ok
+}
+
+// Enqueue 预设接口接下来的响应，按顺序使用，用完后恢复正常
+// 对于EndpointLive，预设的是握手失败时的响应
+func (s *Server) Enqueue(endpoint string, rsps ...Response) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.scripts[endpoint] = append(s.scripts[endpoint], rsps...)
+}
+
+// Requests 返回接口收到的请求数
+func (s *Server) Requests(endpoint string) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.requests[endpoint]
+}
+
+// Fetches 返回每次fetch请求的物品ID
+func (s *Server) Fetches() [][]string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	res := make([][]string, len(s.fetches))
+	copy(res, s.fetches)
+	return res
+}
+
+// NextLive 等待下一个直播搜索连接
+func (s *Server) NextLive(ctx context.Context) (*LiveConn, error) {
+	select {
+	case c := <-s.liveConns:
+		return c, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// next 记录请求，有预设响应时返回
+func (s *Server) next(endpoint string) (Response, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.requests[endpoint]++
+	rsps := s.scripts[endpoint]
+	if len(rsps) == 0 {
+		return Response{}, false
+	}
+	s.scripts[endpoint] = rsps[1:]
+	return rsps[0], true
+}
+
+func writeResponse(w http.ResponseWriter, rsp Response) {
+	for k, vs := range rsp.Header {
+		for _, v := range vs {
+			w.Header().Add(k, v)
+		}
+	}
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	status := rsp.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+	_, _ = io.WriteString(w, rsp.Body)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		writeResponse(w, Status(http.StatusInternalServerError))
+		return
+	}
+	writeResponse(w, Response{Body: string(body)})
+}
+
+func (s *Server) handleFetch(w http.ResponseWriter, r *http.Request) {
+	ids := strings.Split(strings.TrimPrefix(r.URL.Path, apiPath+"/fetch/"), ",")
+	s.lock.Lock()
+	s.fetches = append(s.fetches, ids)
+	s.lock.Unlock()
+	if rsp, ok := s.next(EndpointFetch); ok {
+		writeResponse(w, rsp)
+		return
+	}
+
+	s.lock.Lock()
+	result := make([]*poetrader.PoeGood, len(ids))
+	for i, id := range ids {
+		result[i] = s.goods[id]
+	}
+	s.lock.Unlock()
+	writeJSON(w, map[string]interface{}{"result": result})
+}
+
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	if rsp, ok := s.next(EndpointSearch); ok {
+		writeResponse(w, rsp)
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeResponse(w, Status(http.StatusMethodNotAllowed))
+		return
+	}
+	if body, _ := io.ReadAll(r.Body); !json.Valid(body) {
+		writeResponse(w, Status(http.StatusBadRequest))
+		return
+	}
+
+	s.lock.Lock()
+	res := &poetrader.SearchRes{
+		ID:     s.searchID,
+		Result: append([]string{}, s.searchResult...),
+		Total:  len(s.searchResult),
+	}
+	s.lock.Unlock()
+	writeJSON(w, res)
+}
+
+func (s *Server) handleAccount(w http.ResponseWriter, r *http.Request) {
+	if rsp, ok := s.next(EndpointAccount); ok {
+		writeResponse(w, rsp)
+		return
+	}
+	s.lock.Lock()
+	name := s.accountName
+	s.lock.Unlock()
+	if name == "" {
+		writeJSON(w, map[string]interface{}{})
+		return
+	}
+	writeJSON(w, map[string]string{"accountName": name})
+}
+
+func (s *Server) handleLive(w http.ResponseWriter, r *http.Request) {
+	if rsp, ok := s.next(EndpointLive); ok {
+		writeResponse(w, rsp)
+		return
+	}
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	parts := strings.Split(r.URL.Path, "/")
+	c := &LiveConn{
+		SearchID: parts[len(parts)-1],
+		Header:   r.Header.Clone(),
+		conn:     conn,
+		done:     make(chan struct{}),
+	}
+	defer close(c.done)
+
+	s.lock.Lock()
+	auth, ok := s.liveAuth[c.SearchID]
+	s.conns = append(s.conns, c)
+	s.lock.Unlock()
+	if !ok {
+		auth = true
+	}
+	if err := c.Send(fmt.Sprintf(`{"auth":%t}`, auth)); err != nil {
+		c.Disconnect()
+		return
+	}
+	s.liveConns <- c
+
+	// 读取消息以便自动回复ping，客户端断开后结束
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			c.Disconnect()
+			return
+		}
+	}
+}
+
+// LiveConn 服务端的直播搜索连接
+type LiveConn struct {
+	SearchID string
+	// Header 客户端握手时的请求头
+	Header http.Header
+
+	lock sync.Mutex
+	conn *websocket.Conn
+	done chan struct{}
+}
+
+// Push 推送新物品
+func (c *LiveConn) Push(ids ...string) error {
+	body, err := json.Marshal(map[string][]string{"new": ids})
+	if err != nil {
+		return err
+	}
+	return c.Send(string(body))
+}
+
+// Send 发送任意文本消息，可以用来模拟格式错误的消息
+func (c *LiveConn) Send(msg string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.conn.WriteMessage(websocket.TextMessage, []byte(msg))
+}
+
+// Close 发送关闭帧后断开
+func (c *LiveConn) Close() {
+	c.lock.Lock()
+	_ = c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	c.lock.Unlock()
+	c.Disconnect()
+}
+
+// Disconnect 不发送关闭帧直接断开，模拟网络中断
+func (c *LiveConn) Disconnect() {
+	_ = c.conn.Close()
+}
+
+// Done 客户端断开后关闭
+func (c *LiveConn) Done() <-chan struct{} {
+	return c.done
+}
@@ -0,0 +1,153 @@
+package poetrader
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+const proxyDialTimeout = 30 * time.Second
+
+// ParseProxy 支持 http、https、socks5 代理
+func ParseProxy(proxyURL string) (*url.URL, error) {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+		return u, nil
+	}
+	return nil, fmt.Errorf("unsupported proxy scheme %s", u.Scheme)
+}
+
+func newHTTPClient(proxyURL *url.URL) *http.Client {
+	if proxyURL == nil {
+		return http.DefaultClient
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyURL(proxyURL)
+	return &http.Client{Transport: transport}
+}
+
+type dialContextFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// proxyDialContext 返回经过代理连接目标地址的DialContext，用于websocket
+func proxyDialContext(proxyURL *url.URL) dialContextFunc {
+	dialer := &net.Dialer{Timeout: proxyDialTimeout}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		var (
+			conn net.Conn
+			err  error
+		)
+		switch proxyURL.Scheme {
+		case "socks5", "socks5h":
+			conn, err = dialSocks5(ctx, dialer, proxyURL, network, addr)
+		default:
+			conn, err = dialConnect(ctx, dialer, proxyURL, addr)
+		}
+		if err != nil {
+			return nil, &proxyError{err: err}
+		}
+		return conn, nil
+	}
+}
+
+func dialSocks5(ctx context.Context, dialer *net.Dialer, proxyURL *url.URL, network, addr string) (net.Conn, error) {
+	d, err := proxy.FromURL(proxyURL, dialer)
+	if err != nil {
+		return nil, err
+	}
+	if cd, ok := d.(proxy.ContextDialer); ok {
+		return cd.DialContext(ctx, network, addr)
+	}
+	return d.Dial(network, addr)
+}
+
+// dialConnect 通过HTTP CONNECT建立隧道，https代理先和代理建立TLS
+func dialConnect(ctx context.Context, dialer *net.Dialer, proxyURL *url.URL, addr string) (net.Conn, error) {
+	proxyAddr := proxyURL.Host
+	if proxyURL.Port() == "" {
+		if proxyURL.Scheme == "https" {
+			proxyAddr = net.JoinHostPort(proxyURL.Hostname(), "443")
+		} else {
+			proxyAddr = net.JoinHostPort(proxyURL.Hostname(), "80")
+		}
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: proxyURL.Hostname()})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}
+	if proxyURL.User != nil {
+		password, _ := proxyURL.User.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(proxyURL.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+auth)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+		defer func() {
+			_ = conn.SetDeadline(time.Time{})
+		}()
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	// CONNECT成功前代理不会发送额外的数据，这里的缓冲不会丢数据
+	rsp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy connect %s fail, status: %s", addr, rsp.Status)
+	}
+	return conn, nil
+}
+
+type proxyError struct {
+	err error
+}
+
+func (e *proxyError) Error() string {
+	return fmt.Sprintf("%v: %v", ErrProxy, e.err)
+}
+
+func (e *proxyError) Unwrap() []error {
+	return []error{ErrProxy, e.err}
+}
+
+// wrapProxyError 把net/http中连接代理失败的错误标记为ErrProxy
+func wrapProxyError(err error) error {
+	opErr := &net.OpError{}
+	if errors.As(err, &opErr) && opErr.Op == "proxyconnect" {
+		return &proxyError{err: err}
+	}
+	return err
+}
@@ -0,0 +1,330 @@
+package poetrader
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ink19/poewatcher/config"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+const (
+	endpointFetch    = "fetch"
+	endpointSearch   = "search"
+	endpointExchange = "exchange"
+	endpointAccount  = "account"
+	endpointData     = "data"
+)
+
+const defaultRetryAfter = time.Minute
+
+// sharedRule 按IP统计的规则，所有账号共享
+const sharedRule = "ip"
+
+var (
+	limiterOnce     = &sync.Once{}
+	ipLimiter       *RateLimiter
+	limiterLock     sync.Mutex
+	accountLimiters = map[string]*RateLimiter{}
+)
+
+func getIPLimiter() *RateLimiter {
+	limiterOnce.Do(func() {
+		ipLimiter = NewRateLimiter(config.Get().Poe.RateLimit, true)
+	})
+	return ipLimiter
+}
+
+func getAccountLimiter(account string) *RateLimiter {
+	limiterLock.Lock()
+	defer limiterLock.Unlock()
+
+	l, ok := accountLimiters[account]
+	if !ok {
+		l = NewRateLimiter(0, false)
+		accountLimiters[account] = l
+	}
+	return l
+}
+
+// AccountKey 不直接使用cookie，避免在状态接口中暴露
+func AccountKey(cookies string) string {
+	session := cookies
+	for _, item := range strings.Split(cookies, ";") {
+		kv := strings.SplitN(strings.TrimSpace(item), "=", 2)
+		if len(kv) == 2 && kv[0] == "POESESSID" {
+			session = kv[1]
+		}
+	}
+	sum := sha1.Sum([]byte(session))
+	return "account-" + hex.EncodeToString(sum[:4])
+}
+
+// RateLimitState 返回IP和各账号当前的限频状态
+func RateLimitState() map[string]map[string]RatePolicy {
+	res := map[string]map[string]RatePolicy{
+		sharedRule: getIPLimiter().State(),
+	}
+	limiterLock.Lock()
+	defer limiterLock.Unlock()
+	for account, l := range accountLimiters {
+		res[account] = l.State()
+	}
+	return res
+}
+
+// RateWindow 对应 X-Rate-Limit-<Rule> 中的一项 hits:period:penalty
+type RateWindow struct {
+	Hits    int           `json:"hits"`
+	Period  time.Duration `json:"-"`
+	Penalty time.Duration `json:"-"`
+	// PeriodSeconds PenaltySeconds 状态接口中以秒显示
+	PeriodSeconds  int `json:"period_seconds"`
+	PenaltySeconds int `json:"penalty_seconds"`
+	// CurrentHits 窗口内已经发出的请求数，取服务端状态和本地记录的较大值
+	CurrentHits int `json:"current_hits"`
+
+	requests []time.Time
+}
+
+type RatePolicy struct {
+	Name         string                   `json:"name"`
+	Rules        map[string][]*RateWindow `json:"rules"`
+	BlockedUntil time.Time                `json:"blocked_until"`
+}
+
+// RateLimiter 根据响应头中的X-Rate-Limit规则自适应限频，按接口分别统计
+// 在收到服务端规则之前，只使用配置中的固定频率
+// shared为true时只统计IP规则，否则只统计账号等其他规则
+type RateLimiter struct {
+	lock     sync.Mutex
+	base     *rate.Limiter
+	policies map[string]*RatePolicy
+	shared   bool
+}
+
+func NewRateLimiter(limit int, shared bool) *RateLimiter {
+	base := rate.NewLimiter(rate.Inf, 0)
+	if limit > 0 {
+		base = rate.NewLimiter(rate.Limit(limit), limit)
+	}
+	return &RateLimiter{
+		base:     base,
+		policies: map[string]*RatePolicy{},
+		shared:   shared,
+	}
+}
+
+func (l *RateLimiter) tracks(rule string) bool {
+	return (strings.ToLower(rule) == sharedRule) == l.shared
+}
+
+func (l *RateLimiter) Wait(ctx context.Context, endpoint string) error {
+	if err := l.base.Wait(ctx); err != nil {
+		return err
+	}
+	for {
+		wait := l.reserve(endpoint, time.Now())
+		if wait <= 0 {
+			return nil
+		}
+		log.WithContext(ctx).Debugf("%s rate limited, wait %s", endpoint, wait)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// reserve 可以发出请求时记录本次请求并返回0，否则返回需要等待的时间
+func (l *RateLimiter) reserve(endpoint string, now time.Time) time.Duration {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	policy, ok := l.policies[endpoint]
+	if !ok {
+		return 0
+	}
+	wait := policy.BlockedUntil.Sub(now)
+	for _, windows := range policy.Rules {
+		for _, w := range windows {
+			w.expire(now)
+			if len(w.requests) < w.Hits {
+				continue
+			}
+			if d := w.requests[len(w.requests)-w.Hits].Add(w.Period).Sub(now); d > wait {
+				wait = d
+			}
+		}
+	}
+	if wait > 0 {
+		return wait
+	}
+	for _, windows := range policy.Rules {
+		for _, w := range windows {
+			w.requests = append(w.requests, now)
+			w.CurrentHits = len(w.requests)
+		}
+	}
+	return 0
+}
+
+// Update 用响应头更新规则，429时按Retry-After暂停该接口
+func (l *RateLimiter) Update(endpoint string, rsp *http.Response) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := time.Now()
+	policy, ok := l.policies[endpoint]
+	if !ok {
+		policy = &RatePolicy{Rules: map[string][]*RateWindow{}}
+	}
+
+	if rules := rsp.Header.Get("X-Rate-Limit-Rules"); rules != "" {
+		policy.Name = rsp.Header.Get("X-Rate-Limit-Policy")
+		for _, rule := range strings.Split(rules, ",") {
+			rule = strings.TrimSpace(rule)
+			if !l.tracks(rule) {
+				continue
+			}
+			windows := parseRateWindows(rsp.Header.Get("X-Rate-Limit-"+rule), true)
+			states := parseRateWindows(rsp.Header.Get("X-Rate-Limit-"+rule+"-State"), false)
+			old := policy.Rules[strings.ToLower(rule)]
+			for _, w := range windows {
+				// 保留本地的请求记录
+				for _, o := range old {
+					if o.Period == w.Period {
+						w.requests = o.requests
+					}
+				}
+				w.expire(now)
+				// 状态格式为 hits:period:restricted，无效的规则会被跳过，按周期对应
+				var state *RateWindow
+				for _, st := range states {
+					if st.Period == w.Period {
+						state = st
+						break
+					}
+				}
+				if state == nil {
+					continue
+				}
+				for len(w.requests) < state.Hits {
+					w.requests = append(w.requests, now)
+				}
+				w.CurrentHits = len(w.requests)
+				if restricted := state.Penalty; restricted > 0 {
+					log.Warnf("%s rule %s restricted for %s", endpoint, rule, restricted)
+					if until := now.Add(restricted); until.After(policy.BlockedUntil) {
+						policy.BlockedUntil = until
+					}
+				}
+			}
+			policy.Rules[strings.ToLower(rule)] = windows
+		}
+	}
+
+	// 无法确定429由哪条规则触发时，只暂停账号，避免影响其他账号
+	if rsp.StatusCode == http.StatusTooManyRequests && !l.shared {
+		retryAfter := defaultRetryAfter
+		if v, err := strconv.Atoi(rsp.Header.Get("Retry-After")); err == nil && v > 0 {
+			retryAfter = time.Duration(v) * time.Second
+		}
+		log.Warnf("%s rate limited by server, retry after %s", endpoint, retryAfter)
+		if until := now.Add(retryAfter); until.After(policy.BlockedUntil) {
+			policy.BlockedUntil = until
+		}
+	}
+
+	l.policies[endpoint] = policy
+}
+
+// State 返回各接口当前的限频状态
+func (l *RateLimiter) State() map[string]RatePolicy {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := time.Now()
+	res := map[string]RatePolicy{}
+	for endpoint, policy := range l.policies {
+		p := RatePolicy{
+			Name:         policy.Name,
+			Rules:        map[string][]*RateWindow{},
+			BlockedUntil: policy.BlockedUntil,
+		}
+		for rule, windows := range policy.Rules {
+			for _, w := range windows {
+				w.expire(now)
+				w.CurrentHits = len(w.requests)
+				p.Rules[rule] = append(p.Rules[rule], &RateWindow{
+					Hits:           w.Hits,
+					Period:         w.Period,
+					Penalty:        w.Penalty,
+					PeriodSeconds:  int(w.Period / time.Second),
+					PenaltySeconds: int(w.Penalty / time.Second),
+					CurrentHits:    w.CurrentHits,
+				})
+			}
+		}
+		res[endpoint] = p
+	}
+	return res
+}
+
+func (w *RateWindow) expire(now time.Time) {
+	i := 0
+	for i < len(w.requests) && now.Sub(w.requests[i]) >= w.Period {
+		i++
+	}
+	w.requests = w.requests[i:]
+}
+
+// parseRateWindows 解析 8:10:60,15:60:120 格式
+// rule为true时解析规则，跳过请求数或者周期不是正数的项，否则reserve无法计算等待时间
+func parseRateWindows(v string, rule bool) []*RateWindow {
+	res := []*RateWindow{}
+	if v == "" {
+		return res
+	}
+	for _, item := range strings.Split(v, ",") {
+		parts := strings.Split(strings.TrimSpace(item), ":")
+		if len(parts) != 3 {
+			log.Errorf("invalid rate limit %s", item)
+			continue
+		}
+		values := make([]int, 3)
+		valid := true
+		for i, p := range parts {
+			n, err := strconv.Atoi(p)
+			if err != nil {
+				log.Errorf("invalid rate limit %s, err: %v", item, err)
+				valid = false
+				break
+			}
+			values[i] = n
+		}
+		if !valid {
+			continue
+		}
+		if rule && (values[0] <= 0 || values[1] <= 0) {
+			log.Errorf("invalid rate limit %s, hits and period must be positive", item)
+			continue
+		}
+		res = append(res, &RateWindow{
+			Hits:    values[0],
+			Period:  time.Duration(values[1]) * time.Second,
+			Penalty: time.Duration(values[2]) * time.Second,
+		})
+	}
+	return res
+}
@@ -0,0 +1,46 @@
+package poetrader_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ink19/poewatcher/logic/poetrader"
+)
+
+func rateLimitResponse(rules, state string) *http.Response {
+	rsp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}}
+	rsp.Header.Set("X-Rate-Limit-Policy", "trade-fetch-request-limit")
+	rsp.Header.Set("X-Rate-Limit-Rules", "Account")
+	rsp.Header.Set("X-Rate-Limit-Account", rules)
+	rsp.Header.Set("X-Rate-Limit-Account-State", state)
+	return rsp
+}
+
+// TestRateLimiterInvalidWindow 服务端返回请求数为0的规则时跳过该规则，其余规则仍然生效
+func TestRateLimiterInvalidWindow(t *testing.T) {
+	l := poetrader.NewRateLimiter(0, false)
+	l.Update("fetch", rateLimitResponse("0:60:60,2:10:60", "0:60:0,2:10:0"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := l.Wait(ctx, "fetch"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Wait err = %v, want DeadlineExceeded", err)
+	}
+
+	windows := l.State()["fetch"].Rules["account"]
+	if len(windows) != 1 || windows[0].Hits != 2 || windows[0].CurrentHits != 2 {
+		t.Fatalf("windows = %+v, want only 2:10:60 with 2 hits", windows)
+	}
+	raw, err := json.Marshal(windows[0])
+	if err != nil {
+		t.Fatalf("Marshal fail, err: %v", err)
+	}
+	if s := string(raw); !strings.Contains(s, `"period_seconds":10`) || !strings.Contains(s, `"penalty_seconds":60`) {
+		t.Errorf("json = %s, want period and penalty in seconds", s)
+	}
+}
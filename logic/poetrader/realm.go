@@ -0,0 +1,159 @@
+package poetrader
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/ink19/poewatcher/config"
+)
+
+const (
+	RealmTencent = "tencent"
+	RealmPC      = "pc"
+	RealmXbox    = "xbox"
+	RealmSony    = "sony"
+	RealmPoe2    = "poe2"
+)
+
+// Realm 交易站点，HTTPBase和WSBase形如 https://www.pathofexile.com/api/trade
+type Realm struct {
+	Name     string
+	HTTPBase string
+	WSBase   string
+	// Path 主机平台和PoE2需要在赛季前加上的路径，同时作为fetch的realm参数
+	Path     string
+	// SiteBase 站点根地址，为空时从HTTPBase推导
+	SiteBase string
+	Host     string
+	Origin   string
+	Language string
+}
+
+var realmPresets = map[string]*Realm{
+	RealmTencent: {
+		Name:     RealmTencent,
+		HTTPBase: "https://poe.game.qq.com/api/trade",
+		WSBase:   "wss://poe.game.qq.com/api/trade",
+		Host:     "poe.game.qq.com",
+		Origin:   "https://poe.game.qq.com",
+		Language: "zh-CN,zh;q=0.9,en;q=0.8",
+	},
+	RealmPC: {
+		Name:     RealmPC,
+		HTTPBase: "https://www.pathofexile.com/api/trade",
+		WSBase:   "wss://www.pathofexile.com/api/trade",
+		Host:     "www.pathofexile.com",
+		Origin:   "https://www.pathofexile.com",
+		Language: "en-US,en;q=0.9",
+	},
+	RealmXbox: {
+		Name:     RealmXbox,
+		HTTPBase: "https://www.pathofexile.com/api/trade",
+		WSBase:   "wss://www.pathofexile.com/api/trade",
+		Path:     "xbox",
+		Host:     "www.pathofexile.com",
+		Origin:   "https://www.pathofexile.com",
+		Language: "en-US,en;q=0.9",
+	},
+	RealmSony: {
+		Name:     RealmSony,
+		HTTPBase: "https://www.pathofexile.com/api/trade",
+		WSBase:   "wss://www.pathofexile.com/api/trade",
+		Path:     "sony",
+		Host:     "www.pathofexile.com",
+		Origin:   "https://www.pathofexile.com",
+		Language: "en-US,en;q=0.9",
+	},
+	RealmPoe2: {
+		Name:     RealmPoe2,
+		HTTPBase: "https://www.pathofexile.com/api/trade2",
+		WSBase:   "wss://www.pathofexile.com/api/trade2",
+		Path:     "poe2",
+		Host:     "www.pathofexile.com",
+		Origin:   "https://www.pathofexile.com",
+		Language: "en-US,en;q=0.9",
+	},
+}
+
+// GetRealm 先查找配置中的自定义站点，再查找预设，name为空时使用配置的默认站点
+func GetRealm(name string) (*Realm, error) {
+	if name == "" {
+		name = config.Get().Poe.Realm
+	}
+	if name == "" {
+		name = RealmTencent
+	}
+	if r, ok := config.Get().Poe.Realms[name]; ok {
+		return &Realm{
+			Name:     name,
+			HTTPBase: strings.TrimRight(r.HTTPBase, "/"),
+			WSBase:   strings.TrimRight(r.WSBase, "/"),
+			Path:     r.Path,
+			SiteBase: strings.TrimRight(r.SiteBase, "/"),
+			Host:     r.Host,
+			Origin:   r.Origin,
+			Language: r.Language,
+		}, nil
+	}
+	if r, ok := realmPresets[name]; ok {
+		realm := *r
+		return &realm, nil
+	}
+	return nil, fmt.Errorf("unknown realm %s", name)
+}
+
+func (r *Realm) leaguePath(league string) string {
+	if r.Path == "" {
+		return url.PathEscape(league)
+	}
+	return r.Path + "/" + url.PathEscape(league)
+}
+
+func (r *Realm) SearchURL(league string) string {
+	return r.HTTPBase + "/search/" + r.leaguePath(league)
+}
+
+func (r *Realm) ExchangeURL(league string) string {
+	return r.HTTPBase + "/exchange/" + r.leaguePath(league)
+}
+
+func (r *Realm) LiveURL(league string, searchID string) string {
+	return r.WSBase + "/live/" + r.leaguePath(league) + "/" + url.PathEscape(searchID)
+}
+
+func (r *Realm) DataURL(kind string) string {
+	return r.HTTPBase + "/data/" + kind
+}
+
+func (r *Realm) siteBase() string {
+	site := r.SiteBase
+	if site == "" {
+		site = r.HTTPBase
+		if i := strings.Index(site, "/api/"); i >= 0 {
+			site = site[:i]
+		}
+	}
+	return site
+}
+
+func (r *Realm) AccountNameURL() string {
+	return r.siteBase() + "/character-window/get-account-name"
+}
+
+// TradeURL 交易网站上的搜索页面，比如 https://www.pathofexile.com/trade/search/Standard/xxx
+func (r *Realm) TradeURL(league string, searchID string) string {
+	trade := "/trade"
+	if i := strings.Index(r.HTTPBase, "/api/"); i >= 0 {
+		trade = r.HTTPBase[i+len("/api"):]
+	}
+	return r.siteBase() + trade + "/search/" + r.leaguePath(league) + "/" + url.PathEscape(searchID)
+}
+
+func (r *Realm) FetchURL(searchID string, goodIDs []string) string {
+	fetchURL := r.HTTPBase + "/fetch/" + strings.Join(goodIDs, ",") + "?query=" + url.QueryEscape(searchID)
+	if r.Path != "" {
+		fetchURL += "&realm=" + r.Path
+	}
+	return fetchURL
+}
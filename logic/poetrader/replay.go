@@ -0,0 +1,222 @@
+package poetrader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NewReplayClient 从Recorder录制的cassette文件回放，不访问网络
+// 请求按方法、路径和参数依次匹配录制的响应，直播搜索按连接顺序回放消息
+// 没有指定站点时使用录制时的站点
+func NewReplayClient(path string, opts ...Option) (Client, error) {
+	cas, err := loadCassette(path)
+	if err != nil {
+		return nil, err
+	}
+	seasonID := ""
+	if cas.meta != nil {
+		seasonID = cas.meta.SeasonID
+		if cas.meta.Realm != nil {
+			opts = append([]Option{WithRealm(cas.meta.Realm)}, opts...)
+		}
+	}
+	opts = append(opts, func(c *client) {
+		c.replay = cas
+	})
+	return New(seasonID, "", opts...), nil
+}
+
+type replaySession struct {
+	dial   *Interaction
+	frames []*Interaction
+	end    *Interaction
+	used   bool
+}
+
+type cassette struct {
+	lock     sync.Mutex
+	meta     *Interaction
+	http     []*Interaction
+	httpUsed []bool
+	sessions []*replaySession
+}
+
+func loadCassette(path string) (*cassette, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	cas := &cassette{}
+	sessions := map[int]*replaySession{}
+	dec := json.NewDecoder(file)
+	for {
+		i := &Interaction{}
+		if err := dec.Decode(i); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("decode cassette %s fail: %w", path, err)
+		}
+		switch i.Kind {
+		case interactionMeta:
+			if cas.meta == nil {
+				cas.meta = i
+			}
+		case interactionHTTP:
+			cas.http = append(cas.http, i)
+			cas.httpUsed = append(cas.httpUsed, false)
+		case interactionWSDial:
+			s := &replaySession{dial: i}
+			sessions[i.Conn] = s
+			cas.sessions = append(cas.sessions, s)
+		case interactionWSFrame, interactionWSError:
+			s, ok := sessions[i.Conn]
+			if !ok {
+				return nil, fmt.Errorf("cassette %s: frame %d of unknown conn %d", path, i.Seq, i.Conn)
+			}
+			if i.Kind == interactionWSFrame {
+				s.frames = append(s.frames, i)
+			} else {
+				s.end = i
+			}
+		}
+	}
+	return cas, nil
+}
+
+// requestKey 忽略主机，录制和回放的站点地址可以不同
+func requestKey(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return u.EscapedPath() + "?" + u.RawQuery
+}
+
+func (cas *cassette) RoundTrip(req *http.Request) (*http.Response, error) {
+	key := requestKey(req.URL.String())
+	cas.lock.Lock()
+	var found *Interaction
+	for idx, i := range cas.http {
+		if !cas.httpUsed[idx] && i.Method == req.Method && requestKey(i.URL) == key {
+			cas.httpUsed[idx] = true
+			found = i
+			break
+		}
+	}
+	cas.lock.Unlock()
+
+	if found == nil {
+		return nil, fmt.Errorf("%w: %s %s", ErrReplayExhausted, req.Method, key)
+	}
+	if found.Status == 0 {
+		return nil, errors.New(found.Error)
+	}
+	return replayResponse(found, req), nil
+}
+
+func replayResponse(i *Interaction, req *http.Request) *http.Response {
+	header := i.ResponseHeader
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", i.Status, http.StatusText(i.Status)),
+		StatusCode:    i.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(strings.NewReader(i.ResponseBody)),
+		ContentLength: int64(len(i.ResponseBody)),
+		Request:       req,
+	}
+}
+
+func (cas *cassette) dial(ctx context.Context, rawURL string, header http.Header) (wsConn, *http.Response, error) {
+	key := requestKey(rawURL)
+	cas.lock.Lock()
+	var found *replaySession
+	for _, s := range cas.sessions {
+		if !s.used && requestKey(s.dial.URL) == key {
+			s.used = true
+			found = s
+			break
+		}
+	}
+	cas.lock.Unlock()
+
+	if found == nil {
+		return nil, nil, fmt.Errorf("%w: websocket %s", ErrReplayExhausted, key)
+	}
+	if found.dial.Error != "" {
+		if found.dial.Status == 0 {
+			return nil, nil, errors.New(found.dial.Error)
+		}
+		return nil, replayResponse(found.dial, nil), errors.New(found.dial.Error)
+	}
+	return &replayConn{
+		frames: found.frames,
+		end:    found.end,
+		closed: make(chan struct{}),
+	}, nil, nil
+}
+
+// replayConn 依次返回录制的消息，录制时连接没有断开则一直阻塞到Close
+type replayConn struct {
+	lock      sync.Mutex
+	frames    []*Interaction
+	end       *Interaction
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (c *replayConn) ReadMessage() (int, []byte, error) {
+	c.lock.Lock()
+	if len(c.frames) > 0 {
+		f := c.frames[0]
+		c.frames = c.frames[1:]
+		c.lock.Unlock()
+		return f.MessageType, []byte(f.Message), nil
+	}
+	end := c.end
+	c.end = nil
+	c.lock.Unlock()
+	if end != nil {
+		return 0, nil, errors.New(end.Error)
+	}
+	<-c.closed
+	return 0, nil, net.ErrClosed
+}
+
+func (c *replayConn) WriteMessage(messageType int, data []byte) error {
+	return nil
+}
+
+func (c *replayConn) WriteControl(messageType int, data []byte, deadline time.Time) error {
+	return nil
+}
+
+func (c *replayConn) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *replayConn) SetPongHandler(h func(appData string) error) {}
+
+func (c *replayConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+	})
+	return nil
+}
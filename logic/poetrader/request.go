@@ -1,6 +1,7 @@
 package poetrader
 
 import (
+	"bytes"
 	"context"
 	"io"
 	"net/http"
@@ -8,28 +9,63 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
-func (c *client) request(ctx context.Context, url string) ([]byte, error) {
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+func (c *client) request(ctx context.Context, endpoint string, url string) ([]byte, error) {
+	return c.doRequest(ctx, endpoint, http.MethodGet, url, nil)
+}
+
+func (c *client) postJSON(ctx context.Context, endpoint string, url string, body []byte) ([]byte, error) {
+	return c.doRequest(ctx, endpoint, http.MethodPost, url, body)
+}
+
+func (c *client) doRequest(ctx context.Context, endpoint string, method string, url string, body []byte) ([]byte, error) {
+	err := c.limiter.Wait(ctx, endpoint)
+	if err != nil {
+		log.WithContext(ctx).Errorf("Rate limit fail, err: %v", err)
+		return nil, err
+	}
+	err = c.ipLimiter.Wait(ctx, endpoint)
+	if err != nil {
+		log.WithContext(ctx).Errorf("Rate limit fail, err: %v", err)
+		return nil, err
+	}
+
+	var reqBody io.Reader
+	if body != nil {
+		reqBody = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
 	if err != nil {
 		log.WithContext(ctx).Errorf("NewRequest fail, err: %v", err)
 		return nil, err
 	}
 
 	if c.header != nil {
-		req.Header = *c.header
+		req.Header = c.header.Clone()
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
 	}
 
-	rsp, err := http.DefaultClient.Do(req)
+	rsp, err := c.httpClient.Do(req)
 	if err != nil {
+		err = wrapProxyError(err)
 		log.WithContext(ctx).Errorf("Request fail, err: %v", err)
 		return nil, err
 	}
+	defer rsp.Body.Close()
+	c.limiter.Update(endpoint, rsp)
+	c.ipLimiter.Update(endpoint, rsp)
 
-	body, err := io.ReadAll(rsp.Body)
+	rspBody, err := io.ReadAll(rsp.Body)
 	if err != nil {
 		log.WithContext(ctx).Errorf(" Read rsp, err: %v", err)
 		return nil, err
 	}
 
-	return body, nil
+	if err := newStatusError(rsp, rspBody); err != nil {
+		log.WithContext(ctx).Errorf("Request %s fail, err: %v", url, err)
+		return nil, err
+	}
+
+	return rspBody, nil
 }
@@ -0,0 +1,46 @@
+package poetrader
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type SearchRes struct {
+	ID         string   `json:"id"`
+	Complexity int      `json:"complexity"`
+	Result     []string `json:"result"`
+	Total      int      `json:"total"`
+	Error      *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// Search 用交易查询JSON创建搜索，返回的ID可以用于Watch
+func (c *client) Search(ctx context.Context, query json.RawMessage) (*SearchRes, error) {
+	reqURL := c.realm.SearchURL(c.seasonID)
+	rspBody, err := c.postJSON(ctx, endpointSearch, reqURL, query)
+	if err != nil {
+		log.WithContext(ctx).Errorf("Request fail, err: %v", err)
+		return nil, err
+	}
+	log.WithContext(ctx).Debugf("Search rsp: %s", string(rspBody))
+	res := &SearchRes{}
+	err = json.Unmarshal(rspBody, res)
+	if err != nil {
+		log.WithContext(ctx).Errorf("Unmarshal fail, err: %v", err)
+		return nil, err
+	}
+	if res.Error != nil {
+		log.WithContext(ctx).Errorf("Search fail, code: %d, msg: %s", res.Error.Code, res.Error.Message)
+		return nil, fmt.Errorf("search fail, code: %d, msg: %s", res.Error.Code, res.Error.Message)
+	}
+	if res.ID == "" {
+		log.WithContext(ctx).Errorf("Empty search id")
+		return nil, fmt.Errorf("empty search id")
+	}
+	return res, nil
+}
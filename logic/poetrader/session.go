@@ -0,0 +1,31 @@
+package poetrader
+
+import (
+	"context"
+	"encoding/json"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type accountNameRes struct {
+	AccountName string `json:"accountName"`
+}
+
+func (c *client) CheckSession(ctx context.Context) (string, error) {
+	rspBody, err := c.request(ctx, endpointAccount, c.realm.AccountNameURL())
+	if err != nil {
+		log.WithContext(ctx).Errorf("Request fail, err: %v", err)
+		return "", err
+	}
+	res := &accountNameRes{}
+	err = json.Unmarshal(rspBody, res)
+	if err != nil {
+		log.WithContext(ctx).Errorf("Unmarshal fail, err: %v", err)
+		return "", err
+	}
+	// 未登录时不返回账号名
+	if res.AccountName == "" {
+		return "", ErrUnauthorized
+	}
+	return res.AccountName, nil
+}
@@ -0,0 +1,282 @@
+// Package pricing 通货估值，把报价统一折算为chaos和divine
+package pricing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ink19/poewatcher/config"
+	"github.com/ink19/poewatcher/logic/dao"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	CurrencyChaos  = "chaos"
+	CurrencyDivine = "divine"
+
+	defaultRefreshInterval = time.Hour
+	// failRetryInterval 没有任何数据时，获取失败后等待多久再重试
+	failRetryInterval = time.Minute
+)
+
+var (
+	// ErrNoProvider 没有配置价格来源
+	ErrNoProvider = errors.New("no price provider")
+	// ErrUnknownCurrency 价格数据中没有该通货
+	ErrUnknownCurrency = errors.New("unknown currency")
+)
+
+func refreshInterval() time.Duration {
+	if v := config.Get().Price.RefreshInterval; v > 0 {
+		return time.Duration(v) * time.Minute
+	}
+	return defaultRefreshInterval
+}
+
+type entry struct {
+	rates *dao.CurrencyRates
+	// checkedAt 最后一次从数据库或者价格来源加载的时间，获取失败时也会更新，避免反复请求
+	checkedAt time.Time
+	err       error
+	// loading 正在获取时不为nil，获取结束后关闭
+	loading chan struct{}
+}
+
+var (
+	// lock 只保护内存缓存，获取价格时不持有
+	lock  sync.Mutex
+	cache = map[string]*entry{}
+	// providers 为nil时使用配置中的价格来源
+	providers []Provider
+)
+
+// SetProviders 替换配置中的价格来源并清空内存缓存，不传参数时恢复使用配置
+func SetProviders(ps ...Provider) {
+	lock.Lock()
+	defer lock.Unlock()
+	if len(ps) == 0 {
+		ps = nil
+	}
+	providers = ps
+	cache = map[string]*entry{}
+}
+
+// Get 返回赛季的通货价格，依次使用内存、数据库中未过期的缓存，过期或者refresh为true时重新获取
+// 同一赛季同一时间只获取一次，获取期间其他调用方直接使用旧数据，没有旧数据时等待获取结束
+// 所有来源都失败时如果有旧数据则返回旧数据
+func Get(ctx context.Context, league string, refresh bool) (*dao.CurrencyRates, error) {
+	lock.Lock()
+	e, ok := cache[league]
+	if !ok {
+		e = &entry{}
+		cache[league] = e
+	}
+	if loading := e.loading; loading != nil {
+		rates := e.rates
+		lock.Unlock()
+		if rates != nil {
+			return rates, nil
+		}
+		select {
+		case <-loading:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		if e.rates != nil {
+			return e.rates, nil
+		}
+		return nil, e.err
+	}
+	if ok && !refresh {
+		if e.rates == nil && time.Since(e.checkedAt) < failRetryInterval {
+			lock.Unlock()
+			return nil, e.err
+		}
+		if e.rates != nil && time.Since(e.checkedAt) < refreshInterval() {
+			lock.Unlock()
+			return e.rates, nil
+		}
+	}
+	e.loading = make(chan struct{})
+	ps := providers
+	lock.Unlock()
+
+	var cached *dao.CurrencyRates
+	if !ok {
+		var err error
+		if cached, err = dao.NewClient().GetCurrencyRates(ctx, league); err != nil {
+			logrus.WithContext(ctx).Errorf("GetCurrencyRates fail, err: %v", err)
+		}
+		if cached != nil && !refresh && time.Since(cached.UpdatedAt) < refreshInterval() {
+			return e.finish(cached, nil), nil
+		}
+	}
+
+	rates, err := download(ctx, league, ps)
+	if err == nil {
+		if err := dao.NewClient().SaveCurrencyRates(ctx, rates); err != nil {
+			logrus.WithContext(ctx).Errorf("SaveCurrencyRates fail, err: %v", err)
+		}
+	} else if cached != nil {
+		rates = cached
+	}
+	res := e.finish(rates, err)
+	if err != nil {
+		if res == nil {
+			return nil, err
+		}
+		logrus.WithContext(ctx).Warnf("get currency rates of %s fail, use cache of %s, err: %v", league, res.UpdatedAt, err)
+	}
+	return res, nil
+}
+
+// finish 保存获取结果并唤醒等待的调用方，rates为nil时保留旧数据，返回缓存中的数据
+func (e *entry) finish(rates *dao.CurrencyRates, err error) *dao.CurrencyRates {
+	lock.Lock()
+	defer lock.Unlock()
+	e.checkedAt = time.Now()
+	if rates != nil {
+		e.rates = rates
+	}
+	e.err = err
+	close(e.loading)
+	e.loading = nil
+	return e.rates
+}
+
+// download 按顺序尝试价格来源，返回第一个成功的结果，ps为nil时使用配置中的价格来源
+func download(ctx context.Context, league string, ps []Provider) (*dao.CurrencyRates, error) {
+	if ps == nil {
+		var err error
+		if ps, err = configProviders(); err != nil {
+			return nil, err
+		}
+	}
+	if len(ps) == 0 {
+		return nil, ErrNoProvider
+	}
+	errs := []error{}
+	for _, p := range ps {
+		rates, err := p.Rates(ctx, league)
+		if err != nil {
+			logrus.WithContext(ctx).Warnf("get currency rates of %s from %s fail, err: %v", league, p.Name(), err)
+			errs = append(errs, fmt.Errorf("%s: %w", p.Name(), err))
+			continue
+		}
+		rates[CurrencyChaos] = 1
+		return &dao.CurrencyRates{
+			League:    league,
+			Source:    p.Name(),
+			Rates:     rates,
+			UpdatedAt: time.Now(),
+		}, nil
+	}
+	return nil, errors.Join(errs...)
+}
+
+// Value 报价折算后的价值，Divine在价格数据中没有divine时为0
+type Value struct {
+	Amount   float64 `json:"amount"`
+	Currency string  `json:"currency"`
+	Chaos    float64 `json:"chaos"`
+	Divine   float64 `json:"divine"`
+}
+
+func (v *Value) String() string {
+	if v.Divine == 0 {
+		return fmt.Sprintf("%.1f chaos", v.Chaos)
+	}
+	return fmt.Sprintf("%.1f chaos / %.2f divine", v.Chaos, v.Divine)
+}
+
+// Normalize 把amount个currency折算为chaos和divine
+func Normalize(ctx context.Context, league string, amount float64, currency string) (*Value, error) {
+	rates, err := Get(ctx, league, false)
+	if err != nil {
+		return nil, err
+	}
+	rate, ok := rates.Rates[currency]
+	if currency == CurrencyChaos {
+		rate, ok = 1, true
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownCurrency, currency)
+	}
+	v := &Value{Amount: amount, Currency: currency, Chaos: amount * rate}
+	if divine := rates.Rates[CurrencyDivine]; divine > 0 {
+		v.Divine = v.Chaos / divine
+	}
+	return v, nil
+}
+
+// Refresher 定时刷新正在使用的赛季的价格
+type Refresher struct {
+	leagues func() []string
+
+	stopChan chan struct{}
+	stopOnce *sync.Once
+	wg       sync.WaitGroup
+}
+
+func NewRefresher(leagues func() []string) *Refresher {
+	return &Refresher{
+		leagues:  leagues,
+		stopChan: make(chan struct{}),
+		stopOnce: &sync.Once{},
+	}
+}
+
+func (r *Refresher) Run() {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		r.RefreshAll(context.Background())
+		ticker := time.NewTicker(refreshInterval())
+		defer ticker.Stop()
+		for {
+			select {
+			case <-r.stopChan:
+				return
+			case <-ticker.C:
+				r.RefreshAll(context.Background())
+			}
+		}
+	}()
+}
+
+func (r *Refresher) Stop() {
+	r.stopOnce.Do(func() {
+		close(r.stopChan)
+	})
+	r.wg.Wait()
+}
+
+// RefreshAll 每个赛季只刷新一次，没有配置价格来源时跳过
+func (r *Refresher) RefreshAll(ctx context.Context) {
+	uniq := map[string]struct{}{}
+	for _, league := range r.leagues() {
+		if league != "" {
+			uniq[league] = struct{}{}
+		}
+	}
+	leagues := make([]string, 0, len(uniq))
+	for league := range uniq {
+		leagues = append(leagues, league)
+	}
+	sort.Strings(leagues)
+	for _, league := range leagues {
+		_, err := Get(ctx, league, true)
+		if errors.Is(err, ErrNoProvider) {
+			return
+		}
+		if err != nil {
+			logrus.WithContext(ctx).Errorf("refresh currency rates of %s fail, err: %v", league, err)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package pricing
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ink19/poewatcher/config"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const ninjaBody = `{
+	"lines": [
+		{"currencyTypeName": "Divine Orb", "chaosEquivalent": 200},
+		{"currencyTypeName": "Exalted Orb", "chaosEquivalent": 12.5},
+		{"currencyTypeName": "Mirror Shard", "chaosEquivalent": 3000}
+	],
+	"currencyDetails": [
+		{"name": "Divine Orb", "tradeId": "divine"},
+		{"name": "Exalted Orb", "tradeId": "exalted"},
+		{"name": "Mirror Shard"}
+	]
+}`
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "poewatcher-pricing")
+	if err != nil {
+		panic(err)
+	}
+	config.Get().DB.Path = filepath.Join(dir, "test.db")
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// newNinjaServer fail为true时返回500
+func newNinjaServer(t *testing.T, fail *atomic.Bool, hits *atomic.Int32) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if fail.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if r.URL.Query().Get("league") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(ninjaBody))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestNormalize(t *testing.T) {
+	var fail atomic.Bool
+	var hits atomic.Int32
+	srv := newNinjaServer(t, &fail, &hits)
+	SetProviders(NewNinjaProvider(srv.URL+"?league={league}&type=Currency", nil))
+	defer SetProviders()
+
+	ctx := context.Background()
+	v, err := Normalize(ctx, t.Name(), 5, "divine")
+	if err != nil {
+		t.Fatalf("Normalize fail, err: %v", err)
+	}
+	if v.Chaos != 1000 || v.Divine != 5 {
+		t.Errorf("value = %+v, want 1000 chaos / 5 divine", v)
+	}
+	v, err = Normalize(ctx, t.Name(), 100, "chaos")
+	if err != nil || math.Abs(v.Divine-0.5) > 1e-9 {
+		t.Errorf("value = %+v, err: %v", v, err)
+	}
+	if _, err := Normalize(ctx, t.Name(), 1, "mirror"); !errors.Is(err, ErrUnknownCurrency) {
+		t.Errorf("err = %v, want ErrUnknownCurrency", err)
+	}
+	if n := hits.Load(); n != 1 {
+		t.Errorf("hits = %d, want 1", n)
+	}
+}
+
+func TestFallbackAndCache(t *testing.T) {
+	var fail atomic.Bool
+	var hits atomic.Int32
+	srv := newNinjaServer(t, &fail, &hits)
+	path := filepath.Join(t.TempDir(), "prices.json")
+	if err := os.WriteFile(path, []byte(`{"divine": 150}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	providers := []Provider{NewNinjaProvider(srv.URL+"?league={league}", nil), NewFileProvider(path)}
+	SetProviders(providers...)
+	defer SetProviders()
+	ctx := context.Background()
+
+	// 接口失败时使用文件
+	fail.Store(true)
+	rates, err := Get(ctx, t.Name(), false)
+	if err != nil {
+		t.Fatalf("Get fail, err: %v", err)
+	}
+	if rates.Source != "file" || rates.Rates["divine"] != 150 || rates.Rates["chaos"] != 1 {
+		t.Errorf("rates = %+v", rates)
+	}
+
+	// 刷新成功后写入数据库，清空内存缓存后从数据库读取
+	fail.Store(false)
+	if rates, err = Get(ctx, t.Name(), true); err != nil || rates.Source != "http" {
+		t.Fatalf("Get = %+v, err: %v", rates, err)
+	}
+	SetProviders(providers...)
+	fail.Store(true)
+	before := hits.Load()
+	if rates, err = Get(ctx, t.Name(), false); err != nil || rates.Rates["divine"] != 200 {
+		t.Fatalf("Get = %+v, err: %v", rates, err)
+	}
+	if hits.Load() != before {
+		t.Errorf("fresh db cache should not request again")
+	}
+
+	// 所有来源都失败时返回旧数据
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if rates, err = Get(ctx, t.Name(), true); err != nil || rates.Rates["divine"] != 200 {
+		t.Errorf("Get = %+v, err: %v", rates, err)
+	}
+}
+
+// blockingProvider 每次请求等待release，用来模拟很慢的价格来源
+type blockingProvider struct {
+	hits    atomic.Int32
+	release chan struct{}
+}
+
+func (p *blockingProvider) Name() string { return "blocking" }
+
+func (p *blockingProvider) Rates(ctx context.Context, league string) (map[string]float64, error) {
+	p.hits.Add(1)
+	select {
+	case <-p.release:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return map[string]float64{"divine": float64(100 * p.hits.Load())}, nil
+}
+
+func TestGetSingleDownload(t *testing.T) {
+	p := &blockingProvider{release: make(chan struct{})}
+	SetProviders(p)
+	defer SetProviders()
+	ctx := context.Background()
+
+	// 没有旧数据时并发请求只获取一次，都等待获取结果
+	results := make(chan float64, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			rates, err := Get(ctx, t.Name(), false)
+			if err != nil {
+				t.Errorf("Get fail, err: %v", err)
+				results <- 0
+				return
+			}
+			results <- rates.Rates["divine"]
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	p.release <- struct{}{}
+	for i := 0; i < 3; i++ {
+		if v := <-results; v != 100 {
+			t.Errorf("divine = %v, want 100", v)
+		}
+	}
+	if n := p.hits.Load(); n != 1 {
+		t.Errorf("hits = %d, want 1", n)
+	}
+
+	// 刷新期间其他调用方直接得到旧数据
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if rates, err := Get(ctx, t.Name(), true); err != nil || rates.Rates["divine"] != 200 {
+			t.Errorf("refresh = %+v, err: %v", rates, err)
+		}
+	}()
+	for p.hits.Load() != 2 {
+		time.Sleep(time.Millisecond)
+	}
+	if rates, err := Get(ctx, t.Name(), true); err != nil || rates.Rates["divine"] != 100 {
+		t.Errorf("Get during refresh = %+v, err: %v", rates, err)
+	}
+	p.release <- struct{}{}
+	<-done
+	if n := p.hits.Load(); n != 2 {
+		t.Errorf("hits = %d, want 2", n)
+	}
+}
+
+func TestNoProvider(t *testing.T) {
+	SetProviders()
+	if _, err := Normalize(context.Background(), t.Name(), 1, "divine"); !errors.Is(err, ErrNoProvider) {
+		t.Errorf("err = %v, want ErrNoProvider", err)
+	}
+}
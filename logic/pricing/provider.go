@@ -0,0 +1,142 @@
+package pricing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ink19/poewatcher/config"
+	"github.com/ink19/poewatcher/logic/poetrader"
+)
+
+const httpTimeout = 30 * time.Second
+
+// Provider 通货价格来源，返回通货标签到chaos数量的映射
+type Provider interface {
+	Name() string
+	Rates(ctx context.Context, league string) (map[string]float64, error)
+}
+
+// ninjaProvider poe.ninja格式的currencyoverview接口
+type ninjaProvider struct {
+	url        string
+	httpClient *http.Client
+}
+
+// NewNinjaProvider rawURL中的{league}会替换为赛季
+func NewNinjaProvider(rawURL string, proxyURL *url.URL) Provider {
+	httpClient := &http.Client{Timeout: httpTimeout}
+	if proxyURL != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = http.ProxyURL(proxyURL)
+		httpClient.Transport = transport
+	}
+	return &ninjaProvider{url: rawURL, httpClient: httpClient}
+}
+
+func (p *ninjaProvider) Name() string {
+	return "http"
+}
+
+type ninjaRsp struct {
+	Lines []struct {
+		CurrencyTypeName string  `json:"currencyTypeName"`
+		ChaosEquivalent  float64 `json:"chaosEquivalent"`
+	} `json:"lines"`
+	CurrencyDetails []struct {
+		Name    string `json:"name"`
+		TradeID string `json:"tradeId"`
+	} `json:"currencyDetails"`
+}
+
+func (p *ninjaProvider) Rates(ctx context.Context, league string) (map[string]float64, error) {
+	reqURL := strings.ReplaceAll(p.url, "{league}", url.QueryEscape(league))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	rsp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+	body, err := io.ReadAll(rsp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if rsp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("get currency rates fail, status: %d", rsp.StatusCode)
+	}
+	data := &ninjaRsp{}
+	if err := json.Unmarshal(body, data); err != nil {
+		return nil, fmt.Errorf("%w: %v", poetrader.ErrInvalidResponse, err)
+	}
+
+	// lines中只有名称，通过currencyDetails转换为交易站点的标签
+	tags := map[string]string{}
+	for _, d := range data.CurrencyDetails {
+		if d.TradeID != "" {
+			tags[d.Name] = d.TradeID
+		}
+	}
+	rates := map[string]float64{}
+	for _, line := range data.Lines {
+		if tag, ok := tags[line.CurrencyTypeName]; ok && line.ChaosEquivalent > 0 {
+			rates[tag] = line.ChaosEquivalent
+		}
+	}
+	if len(rates) == 0 {
+		return nil, fmt.Errorf("no currency rates of league %s", league)
+	}
+	return rates, nil
+}
+
+// fileProvider 本地价格文件，所有赛季使用相同的价格
+type fileProvider struct {
+	path string
+}
+
+func NewFileProvider(path string) Provider {
+	return &fileProvider{path: path}
+}
+
+func (p *fileProvider) Name() string {
+	return "file"
+}
+
+func (p *fileProvider) Rates(ctx context.Context, league string) (map[string]float64, error) {
+	raw, err := os.ReadFile(p.path)
+	if err != nil {
+		return nil, err
+	}
+	rates := map[string]float64{}
+	if err := json.Unmarshal(raw, &rates); err != nil {
+		return nil, fmt.Errorf("parse price file %s fail, err: %w", p.path, err)
+	}
+	return rates, nil
+}
+
+// configProviders 按配置生成价格来源，HTTP优先，文件作为后备
+func configProviders() ([]Provider, error) {
+	providers := []Provider{}
+	if u := config.Get().Price.URL; u != "" {
+		var proxyURL *url.URL
+		if p := config.Get().Poe.Proxy; p != "" {
+			var err error
+			if proxyURL, err = poetrader.ParseProxy(p); err != nil {
+				return nil, err
+			}
+		}
+		providers = append(providers, NewNinjaProvider(u, proxyURL))
+	}
+	if f := config.Get().Price.File; f != "" {
+		providers = append(providers, NewFileProvider(f))
+	}
+	return providers, nil
+}
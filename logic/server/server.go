@@ -3,13 +3,19 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"strconv"
 	"sync"
 
 	"github.com/gin-gonic/gin"
+	"github.com/ink19/poewatcher/config"
 	"github.com/ink19/poewatcher/logic/dao"
+	"github.com/ink19/poewatcher/logic/poetrader"
+	"github.com/ink19/poewatcher/logic/pricing"
+	"github.com/ink19/poewatcher/logic/tradedata"
 	"github.com/ink19/poewatcher/logic/watch"
 	"github.com/sirupsen/logrus"
 )
@@ -34,6 +40,17 @@ func (s *recordStorage) get(id int64) (watch.Watcher, bool) {
 	return r, ok
 }
 
+func (s *recordStorage) list() []watch.Watcher {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	res := make([]watch.Watcher, 0, len(s.data))
+	for _, w := range s.data {
+		res = append(res, w)
+	}
+	return res
+}
+
 func (s *recordStorage) delete(id int64) (watch.Watcher, bool) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
@@ -55,15 +72,29 @@ type server struct{
 	recordStorage
 
 	service *http.Server
+	sessionValidator *watch.SessionValidator
+	priceRefresher *pricing.Refresher
 }
 
 func New() Server {
-	return &server{
+	s := &server{
 		recordStorage: recordStorage{
 			data: make(map[int64]watch.Watcher),
 			lock: sync.RWMutex{},
 		},
 	}
+	s.sessionValidator = watch.NewSessionValidator(s.recordStorage.list)
+	s.priceRefresher = pricing.NewRefresher(s.leagues)
+	return s
+}
+
+// leagues 返回所有记录使用的赛季，用于定时刷新价格
+func (s *server) leagues() []string {
+	res := []string{}
+	for _, w := range s.recordStorage.list() {
+		res = append(res, w.Record().SeasonID)
+	}
+	return res
 }
 
 func (s *server) Run() error {
@@ -74,6 +105,14 @@ func (s *server) Run() error {
 	router.GET("/list", s.list)
 	router.GET("/pause", s.pause)
 	router.GET("/start", s.start)
+	router.GET("/rate_limit", s.rateLimit)
+	router.GET("/sessions", s.sessions)
+	router.GET("/live_slots", s.liveSlots)
+	router.POST("/cookie", s.replaceCookie)
+	router.GET("/trade_data", s.tradeData)
+	router.GET("/prices", s.prices)
+	router.GET("/notifiers", s.notifiers)
+	router.POST("/preview", s.preview)
 
 	records, err := dao.NewClient().ListRecords(context.Background())
 	if err != nil {
@@ -82,12 +121,17 @@ func (s *server) Run() error {
 	}
 	for _, r := range records {
 		w := watch.New(r)
+		s.recordStorage.add(w)
+		// 暂停的记录保持暂停，出错的记录重试一次
+		if r.Status == dao.RecordStatusPending {
+			continue
+		}
 		if err = w.Run(); err != nil {
 			logrus.WithError(err).Error("failed to run watcher")
-			continue
 		}
-		s.recordStorage.add(w)
 	}
+	s.sessionValidator.Run()
+	s.priceRefresher.Run()
 
 	s.service = &http.Server{Addr: ":8080", Handler: router}
 
@@ -107,6 +151,29 @@ func (s *server) add(ctx *gin.Context) {
 		ctx.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
+	if err = validateRecord(ctx, record); err != nil {
+		logrus.WithError(err).Error("invalid record")
+		ctx.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if record.Cookie != "" || record.Account != "" {
+		session := watch.Session{Realm: record.Realm, Cookie: record.Cookie, Proxy: record.Proxy}
+		account, _ := watch.Account(record.Account)
+		if session.Cookie == "" {
+			session.Cookie = account.Cookie
+		}
+		if session.Proxy == "" {
+			session.Proxy = account.Proxy
+		}
+		if session.Proxy == "" {
+			session.Proxy = config.Get().Poe.Proxy
+		}
+		if err = s.sessionValidator.Check(ctx, session); poetrader.IsFatal(err) {
+			logrus.WithError(err).Error("invalid cookie")
+			ctx.JSON(400, gin.H{"error": "invalid cookie: " + err.Error()})
+			return
+		}
+	}
 	
 	w := watch.New(record)
 	if err = w.Run(); err != nil {
@@ -119,6 +186,69 @@ func (s *server) add(ctx *gin.Context) {
 	ctx.JSON(200, gin.H{"id": w.Record().ID})
 }
 
+func validateRecord(ctx context.Context, record *dao.Record) error {
+	if _, err := poetrader.GetRealm(record.Realm); err != nil {
+		return err
+	}
+	if record.SeasonID != "" {
+		err := tradedata.ValidateLeague(ctx, record.Realm, record.SeasonID)
+		if errors.Is(err, tradedata.ErrUnknownLeague) {
+			return err
+		}
+		// 联盟列表获取失败时不阻止添加
+		if err != nil {
+			logrus.WithError(err).Warn("failed to validate league")
+		}
+	}
+	if record.Account != "" {
+		if _, ok := watch.Account(record.Account); !ok {
+			return fmt.Errorf("unknown account %s", record.Account)
+		}
+	}
+	if record.Proxy != "" {
+		if _, err := poetrader.ParseProxy(record.Proxy); err != nil {
+			return err
+		}
+	}
+	if err := watch.ValidateFilter(record.Filter); err != nil {
+		return err
+	}
+	if err := watch.ValidateNotifiers(record.Notifiers); err != nil {
+		return err
+	}
+	if err := watch.ValidateTemplate(record.Template); err != nil {
+		return err
+	}
+	if err := watch.ValidateDigest(record.Digest); err != nil {
+		return err
+	}
+	switch record.Kind {
+	case dao.RecordKindLiveSearch:
+		if record.SearchID == "" && len(record.Query) == 0 {
+			return fmt.Errorf("search_id or query is required")
+		}
+		switch record.Mode {
+		case dao.RecordModeLive:
+		case dao.RecordModePoll, dao.RecordModeAuto:
+			if len(record.Query) == 0 {
+				return fmt.Errorf("query is required for poll mode")
+			}
+		default:
+			return fmt.Errorf("unknown record mode %d", record.Mode)
+		}
+	case dao.RecordKindExchange:
+		if record.Exchange == nil || len(record.Exchange.Have) == 0 || len(record.Exchange.Want) == 0 {
+			return fmt.Errorf("exchange have and want are required")
+		}
+		if record.Exchange.Ratio <= 0 {
+			return fmt.Errorf("exchange ratio must be positive")
+		}
+	default:
+		return fmt.Errorf("unknown record kind %d", record.Kind)
+	}
+	return nil
+}
+
 func (s *server) start(ctx *gin.Context) {
 	idStr, ok := ctx.GetQuery("id")
 	if !ok {
@@ -142,7 +272,12 @@ func (s *server) start(ctx *gin.Context) {
 			ctx.JSON(400, gin.H{"error": err.Error()})
 			return
 		}
+		if record == nil {
+			ctx.JSON(404, gin.H{"error": "not found"})
+			return
+		}
 		w = watch.New(record)
+		s.recordStorage.add(w)
 	}
 
 	if err = w.Run(); err != nil {
@@ -200,7 +335,13 @@ func (s *server) get(ctx *gin.Context) {
 		return
 	}
 
-	ctx.JSON(200, w.Record())
+	ctx.JSON(200, recordStatus{Record: w.Record(), Runtime: w.Status()})
+}
+
+// recordStatus 记录和内存中的运行状态
+type recordStatus struct {
+	*dao.Record
+	Runtime watch.Status `json:"runtime"`
 }
 
 func (s *server) list(ctx *gin.Context) {
@@ -239,9 +380,185 @@ func (s *server) pause(ctx *gin.Context) {
 	ctx.JSON(200, gin.H{"id": w.Record().ID})
 }
 
+func (s *server) rateLimit(ctx *gin.Context) {
+	ctx.JSON(200, poetrader.RateLimitState())
+}
+
+func (s *server) liveSlots(ctx *gin.Context) {
+	ctx.JSON(200, watch.LiveSlotState())
+}
+
+func (s *server) notifiers(ctx *gin.Context) {
+	ctx.JSON(200, watch.Notifiers())
+}
+
+func (s *server) sessions(ctx *gin.Context) {
+	ctx.JSON(200, s.sessionValidator.States())
+}
+
+type previewReq struct {
+	// ID 为0时只能使用示例物品
+	ID       int64               `json:"id"`
+	Template *dao.NotifyTemplate `json:"template,omitempty"`
+	// Sample 为true时使用示例物品，否则使用记录最近一次通知的物品
+	Sample bool `json:"sample"`
+}
+
+// preview 用模板渲染示例物品或者记录最近一次通知的物品，template为空时使用记录的模板
+func (s *server) preview(ctx *gin.Context) {
+	body, err := io.ReadAll(ctx.Request.Body)
+	if err != nil {
+		logrus.WithError(err).Error("failed to read request body")
+		ctx.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	req := &previewReq{}
+	if err = json.Unmarshal(body, req); err != nil {
+		logrus.WithError(err).Error("failed to unmarshal request body")
+		ctx.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	record := &dao.Record{}
+	if req.ID != 0 {
+		w, ok := s.recordStorage.get(req.ID)
+		if !ok {
+			ctx.JSON(404, gin.H{"error": "not found"})
+			return
+		}
+		record = w.Record()
+	}
+	msg, sample, err := watch.Preview(ctx, record, req.Template, req.Sample)
+	if err != nil {
+		ctx.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(200, gin.H{"text": msg.Text, "markdown": msg.Markdown, "sample": sample})
+}
+
+type replaceCookieReq struct {
+	Key    string `json:"key"`
+	Cookie string `json:"cookie"`
+}
+
+func (s *server) replaceCookie(ctx *gin.Context) {
+	body, err := io.ReadAll(ctx.Request.Body)
+	if err != nil {
+		logrus.WithError(err).Error("failed to read request body")
+		ctx.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	req := &replaceCookieReq{}
+	if err = json.Unmarshal(body, req); err != nil {
+		logrus.WithError(err).Error("failed to unmarshal request body")
+		ctx.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Key == "" || req.Cookie == "" {
+		ctx.JSON(400, gin.H{"error": "key and cookie are required"})
+		return
+	}
+
+	restarted, err := s.sessionValidator.ReplaceCookie(ctx, req.Key, req.Cookie)
+	if err != nil {
+		logrus.WithError(err).Error("failed to replace cookie")
+		ctx.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(200, gin.H{"restarted": restarted})
+}
+
+// tradeData 返回缓存的静态数据，stats和static可以用id查询单条
+func (s *server) tradeData(ctx *gin.Context) {
+	kind := ctx.Query("kind")
+	realm := ctx.Query("realm")
+	if id := ctx.Query("id"); id != "" {
+		var (
+			entry interface{}
+			err   error
+		)
+		switch kind {
+		case poetrader.DataStats:
+			var stat *poetrader.Stat
+			if stat, err = tradedata.Stat(ctx, realm, id); stat != nil {
+				entry = stat
+			}
+		case poetrader.DataStatic:
+			var static *poetrader.StaticEntry
+			if static, err = tradedata.StaticEntry(ctx, realm, id); static != nil {
+				entry = static
+			}
+		default:
+			ctx.JSON(400, gin.H{"error": "id is only supported for stats and static"})
+			return
+		}
+		if err != nil {
+			logrus.WithError(err).Error("failed to get trade data")
+			ctx.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		if entry == nil {
+			ctx.JSON(404, gin.H{"error": "not found"})
+			return
+		}
+		ctx.JSON(200, entry)
+		return
+	}
+
+	refresh, _ := strconv.ParseBool(ctx.Query("refresh"))
+	data, err := tradedata.Get(ctx, realm, kind, refresh)
+	if err != nil {
+		logrus.WithError(err).Error("failed to get trade data")
+		ctx.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(200, data)
+}
+
+// prices 返回赛季的通货价格，amount和currency不为空时返回折算结果
+func (s *server) prices(ctx *gin.Context) {
+	league := ctx.Query("league")
+	if league == "" {
+		ctx.JSON(400, gin.H{"error": "league is required"})
+		return
+	}
+	if currency := ctx.Query("currency"); currency != "" {
+		amount, err := strconv.ParseFloat(ctx.DefaultQuery("amount", "1"), 64)
+		if err != nil {
+			ctx.JSON(400, gin.H{"error": "Invalid amount"})
+			return
+		}
+		v, err := pricing.Normalize(ctx, league, amount, currency)
+		if errors.Is(err, pricing.ErrUnknownCurrency) {
+			ctx.JSON(404, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			logrus.WithError(err).Error("failed to normalize price")
+			ctx.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		ctx.JSON(200, v)
+		return
+	}
+
+	refresh, _ := strconv.ParseBool(ctx.Query("refresh"))
+	rates, err := pricing.Get(ctx, league, refresh)
+	if err != nil {
+		logrus.WithError(err).Error("failed to get currency rates")
+		ctx.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(200, rates)
+}
+
 func (s *server) Stop() error {
-	for _, w := range s.recordStorage.data {
-		w.Stop()
+	s.sessionValidator.Stop()
+	s.priceRefresher.Stop()
+	// 退出时不修改数据库中的状态，重启后继续运行
+	for _, w := range s.recordStorage.list() {
+		w.Close()
 	}
 
 	return s.service.Shutdown(context.Background())
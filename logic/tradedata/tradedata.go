@@ -0,0 +1,332 @@
+package tradedata
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ink19/poewatcher/config"
+	"github.com/ink19/poewatcher/logic/dao"
+	"github.com/ink19/poewatcher/logic/poetrader"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultRefreshInterval = 24 * time.Hour
+	// forceRefreshInterval 查找不到时强制刷新的最小间隔，避免错误的赛季名反复触发下载
+	forceRefreshInterval = 10 * time.Minute
+	// failRetryInterval 没有旧数据时，下载失败后等待多久再重试
+	failRetryInterval = time.Minute
+)
+
+// ErrUnknownLeague 赛季不在联盟列表中
+var ErrUnknownLeague = errors.New("unknown league")
+
+func refreshInterval() time.Duration {
+	if v := config.Get().Poe.DataRefreshInterval; v > 0 {
+		return time.Duration(v) * time.Hour
+	}
+	return defaultRefreshInterval
+}
+
+// index 解析后的数据，按站点缓存在内存中
+type index struct {
+	updatedAt time.Time
+	// checkedAt 最后一次从数据库或者站点加载的时间，下载失败使用旧数据时也会更新，避免反复下载
+	checkedAt time.Time
+	leagues   map[string]*poetrader.League
+	stats     map[string]*poetrader.Stat
+	static    map[string]*poetrader.StaticEntry
+}
+
+// call 正在进行的下载，data和err在关闭done之前设置
+type call struct {
+	done chan struct{}
+	data *dao.TradeData
+	err  error
+}
+
+// failure 没有旧数据时下载失败的错误
+type failure struct {
+	at  time.Time
+	err error
+}
+
+var (
+	// lock 只保护内存中的状态，下载时不持有
+	lock    sync.Mutex
+	indexes = map[string]map[string]*index{}
+	// forcedAt 每个站点和kind最后一次强制刷新的时间
+	forcedAt = map[string]time.Time{}
+	// calls 每个站点和kind正在进行的下载
+	calls    = map[string]*call{}
+	failures = map[string]failure{}
+)
+
+// Get 返回站点的静态数据，优先使用数据库中的缓存，过期或者refresh为true时重新下载
+// 同一站点和kind同一时间只下载一次，避免并发请求触发限频，下载期间其他调用方使用旧数据
+// 下载失败时如果有旧数据则返回旧数据，没有旧数据时failRetryInterval内直接返回错误
+func Get(ctx context.Context, realmName string, kind string, refresh bool) (*dao.TradeData, error) {
+	if !poetrader.IsDataKind(kind) {
+		return nil, fmt.Errorf("unknown trade data kind %s", kind)
+	}
+	realm, err := poetrader.GetRealm(realmName)
+	if err != nil {
+		return nil, err
+	}
+
+	key := realm.Name + "/" + kind
+	lock.Lock()
+	if c, ok := calls[key]; ok {
+		lock.Unlock()
+		return wait(ctx, realm.Name, kind, c)
+	}
+	if f, ok := failures[key]; ok && !refresh && time.Since(f.at) < failRetryInterval {
+		lock.Unlock()
+		return nil, f.err
+	}
+	c := &call{done: make(chan struct{})}
+	calls[key] = c
+	lock.Unlock()
+
+	c.data, c.err = load(ctx, realm, kind, refresh)
+	lock.Lock()
+	delete(calls, key)
+	if c.err != nil && ctx.Err() == nil {
+		failures[key] = failure{at: time.Now(), err: c.err}
+	} else if c.err == nil {
+		delete(failures, key)
+	}
+	lock.Unlock()
+	close(c.done)
+	return c.data, c.err
+}
+
+// wait 其他调用方正在下载时使用数据库中的旧数据，没有旧数据时等待下载结束
+func wait(ctx context.Context, realmName string, kind string, c *call) (*dao.TradeData, error) {
+	cached, err := dao.NewClient().GetTradeData(ctx, realmName, kind)
+	if err != nil {
+		logrus.WithContext(ctx).Errorf("GetTradeData fail, err: %v", err)
+		return nil, err
+	}
+	if cached != nil {
+		return cached, nil
+	}
+	select {
+	case <-c.done:
+		return c.data, c.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// load 读取数据库中的缓存，过期或者refresh为true时重新下载
+func load(ctx context.Context, realm *poetrader.Realm, kind string, refresh bool) (*dao.TradeData, error) {
+	cached, err := dao.NewClient().GetTradeData(ctx, realm.Name, kind)
+	if err != nil {
+		logrus.WithContext(ctx).Errorf("GetTradeData fail, err: %v", err)
+		return nil, err
+	}
+	if cached != nil && !refresh && time.Since(cached.UpdatedAt) < refreshInterval() {
+		return cached, nil
+	}
+
+	raw, err := download(ctx, realm, kind)
+	if err != nil {
+		if cached != nil {
+			logrus.WithContext(ctx).Warnf("download %s %s data fail, use cache of %s, err: %v", realm.Name, kind, cached.UpdatedAt, err)
+			return cached, nil
+		}
+		return nil, err
+	}
+	data := &dao.TradeData{
+		Realm:     realm.Name,
+		Kind:      kind,
+		Data:      raw,
+		UpdatedAt: time.Now(),
+	}
+	if err := dao.NewClient().SaveTradeData(ctx, data); err != nil {
+		logrus.WithContext(ctx).Errorf("SaveTradeData fail, err: %v", err)
+	}
+	return data, nil
+}
+
+func download(ctx context.Context, realm *poetrader.Realm, kind string) (json.RawMessage, error) {
+	opts := []poetrader.Option{poetrader.WithRealm(realm), poetrader.WithAccount("trade-data")}
+	if p := config.Get().Poe.Proxy; p != "" {
+		proxyURL, err := poetrader.ParseProxy(p)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, poetrader.WithProxy(proxyURL))
+	}
+	c := poetrader.New("", "", opts...)
+	defer func() {
+		_ = c.Stop(ctx)
+	}()
+	return c.TradeData(ctx, kind)
+}
+
+// getIndex 返回kind对应的内存索引，数据刷新后重新解析，refresh为true时重新下载
+func getIndex(ctx context.Context, realmName string, kind string, refresh bool) (*index, error) {
+	realm, err := poetrader.GetRealm(realmName)
+	if err != nil {
+		return nil, err
+	}
+	// 内存中的索引未过期时不读数据库
+	lock.Lock()
+	idx, ok := indexes[realm.Name][kind]
+	fresh := ok && time.Since(idx.checkedAt) < refreshInterval()
+	lock.Unlock()
+	if fresh && !refresh {
+		return idx, nil
+	}
+
+	data, err := Get(ctx, realmName, kind, refresh)
+	if err != nil {
+		return nil, err
+	}
+
+	lock.Lock()
+	if idx, ok := indexes[data.Realm][kind]; ok && !idx.updatedAt.Before(data.UpdatedAt) {
+		idx.checkedAt = time.Now()
+		lock.Unlock()
+		return idx, nil
+	}
+	lock.Unlock()
+
+	// 解析较慢，不持有锁
+	idx, err = parseIndex(kind, data)
+	if err != nil {
+		logrus.WithContext(ctx).Errorf("parse %s %s data fail, err: %v", data.Realm, kind, err)
+		return nil, err
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if old, ok := indexes[data.Realm][kind]; ok && !old.updatedAt.Before(idx.updatedAt) {
+		old.checkedAt = time.Now()
+		return old, nil
+	}
+	if indexes[data.Realm] == nil {
+		indexes[data.Realm] = map[string]*index{}
+	}
+	indexes[data.Realm][kind] = idx
+	return idx, nil
+}
+
+func parseIndex(kind string, data *dao.TradeData) (*index, error) {
+	idx := &index{updatedAt: data.UpdatedAt, checkedAt: time.Now()}
+	switch kind {
+	case poetrader.DataLeagues:
+		leagues := []*poetrader.League{}
+		if err := json.Unmarshal(data.Data, &leagues); err != nil {
+			return nil, err
+		}
+		idx.leagues = map[string]*poetrader.League{}
+		for _, l := range leagues {
+			idx.leagues[l.ID] = l
+		}
+	case poetrader.DataStats:
+		groups := []*poetrader.StatGroup{}
+		if err := json.Unmarshal(data.Data, &groups); err != nil {
+			return nil, err
+		}
+		idx.stats = map[string]*poetrader.Stat{}
+		for _, g := range groups {
+			for _, s := range g.Entries {
+				idx.stats[s.ID] = s
+			}
+		}
+	case poetrader.DataStatic:
+		groups := []*poetrader.StaticGroup{}
+		if err := json.Unmarshal(data.Data, &groups); err != nil {
+			return nil, err
+		}
+		idx.static = map[string]*poetrader.StaticEntry{}
+		for _, g := range groups {
+			for _, e := range g.Entries {
+				idx.static[e.ID] = e
+			}
+		}
+	default:
+		return nil, fmt.Errorf("trade data kind %s has no index", kind)
+	}
+	return idx, nil
+}
+
+// allowForce 距离上次强制刷新超过forceRefreshInterval时返回true并记录时间
+func allowForce(realmName string, kind string) bool {
+	lock.Lock()
+	defer lock.Unlock()
+	key := realmName + "/" + kind
+	if time.Since(forcedAt[key]) < forceRefreshInterval {
+		return false
+	}
+	forcedAt[key] = time.Now()
+	return true
+}
+
+// ValidateLeague 赛季不存在时返回ErrUnknownLeague，联盟列表获取失败时返回对应错误
+// 缓存中找不到时强制刷新一次，新赛季开始后不用等缓存过期
+func ValidateLeague(ctx context.Context, realmName string, league string) error {
+	idx, err := getIndex(ctx, realmName, poetrader.DataLeagues, false)
+	if err != nil {
+		return err
+	}
+	if _, ok := idx.leagues[league]; ok {
+		return nil
+	}
+	realm, err := poetrader.GetRealm(realmName)
+	if err != nil {
+		return err
+	}
+	if allowForce(realm.Name, poetrader.DataLeagues) {
+		logrus.WithContext(ctx).Infof("league %s not found in %s, refresh leagues", league, realm.Name)
+		if idx, err = getIndex(ctx, realmName, poetrader.DataLeagues, true); err != nil {
+			return err
+		}
+		if _, ok := idx.leagues[league]; ok {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrUnknownLeague, league)
+}
+
+// Stat 根据词缀ID查找，不存在时返回nil
+func Stat(ctx context.Context, realmName string, id string) (*poetrader.Stat, error) {
+	idx, err := getIndex(ctx, realmName, poetrader.DataStats, false)
+	if err != nil {
+		return nil, err
+	}
+	return idx.stats[id], nil
+}
+
+// StaticEntry 根据通货标签查找，不存在时返回nil
+func StaticEntry(ctx context.Context, realmName string, id string) (*poetrader.StaticEntry, error) {
+	idx, err := getIndex(ctx, realmName, poetrader.DataStatic, false)
+	if err != nil {
+		return nil, err
+	}
+	return idx.static[id], nil
+}
+
+// StatText 返回词缀的文本，找不到时返回ID
+func StatText(ctx context.Context, realmName string, id string) string {
+	s, err := Stat(ctx, realmName, id)
+	if err != nil || s == nil {
+		return id
+	}
+	return s.Text
+}
+
+// CurrencyName 返回通货标签对应的名称，找不到时返回标签
+func CurrencyName(ctx context.Context, realmName string, tag string) string {
+	e, err := StaticEntry(ctx, realmName, tag)
+	if err != nil || e == nil {
+		return tag
+	}
+	return e.Text
+}
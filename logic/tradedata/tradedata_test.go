@@ -0,0 +1,120 @@
+package tradedata
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ink19/poewatcher/config"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const staticBody = `{"result":[{"id":"Currency","label":"Currency","entries":[{"id":"divine","text":"Divine Orb"}]}]}`
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "poewatcher-tradedata")
+	if err != nil {
+		panic(err)
+	}
+	config.Get().DB.Path = filepath.Join(dir, "test.db")
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// newDataServer 注册为名称为t.Name()的站点，每个请求等待release，release为nil时返回404
+func newDataServer(t *testing.T, hits *atomic.Int32, release chan struct{}) string {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if release == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		<-release
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(staticBody))
+	}))
+	t.Cleanup(srv.Close)
+	cfg := config.Get()
+	if cfg.Poe.Realms == nil {
+		cfg.Poe.Realms = map[string]config.Realm{}
+	}
+	cfg.Poe.Realms[t.Name()] = config.Realm{HTTPBase: srv.URL + "/api/trade", WSBase: "ws" + srv.URL[len("http"):] + "/api/trade"}
+	return t.Name()
+}
+
+func TestSingleDownload(t *testing.T) {
+	var hits atomic.Int32
+	release := make(chan struct{})
+	realm := newDataServer(t, &hits, release)
+	defer close(release)
+	ctx := context.Background()
+
+	// 并发查找只下载一次，没有旧数据时等待下载结束
+	names := make(chan string, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			names <- CurrencyName(ctx, realm, "divine")
+		}()
+	}
+	for hits.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	release <- struct{}{}
+	for i := 0; i < 3; i++ {
+		if name := <-names; name != "Divine Orb" {
+			t.Errorf("CurrencyName = %s, want Divine Orb", name)
+		}
+	}
+	if n := hits.Load(); n != 1 {
+		t.Errorf("hits = %d, want 1", n)
+	}
+
+	// 刷新期间查找不等待下载
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if _, err := Get(ctx, realm, "static", true); err != nil {
+			t.Errorf("Get fail, err: %v", err)
+		}
+	}()
+	for hits.Load() != 2 {
+		time.Sleep(time.Millisecond)
+	}
+	go func() {
+		names <- CurrencyName(ctx, realm, "divine")
+	}()
+	select {
+	case name := <-names:
+		if name != "Divine Orb" {
+			t.Errorf("CurrencyName = %s, want Divine Orb", name)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("CurrencyName blocked by refresh")
+	}
+	release <- struct{}{}
+	<-done
+}
+
+func TestFailureCache(t *testing.T) {
+	var hits atomic.Int32
+	realm := newDataServer(t, &hits, nil)
+	ctx := context.Background()
+
+	// 下载失败后短时间内不再下载
+	for i := 0; i < 3; i++ {
+		if name := CurrencyName(ctx, realm, "divine"); name != "divine" {
+			t.Errorf("CurrencyName = %s, want divine", name)
+		}
+	}
+	if n := hits.Load(); n != 1 {
+		t.Errorf("hits = %d, want 1", n)
+	}
+}
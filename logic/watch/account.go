@@ -0,0 +1,107 @@
+package watch
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/ink19/poewatcher/config"
+	"github.com/ink19/poewatcher/logic/dao"
+)
+
+// accountPool 记录账号池中每个账号正在被多少个记录使用
+type accountPool struct {
+	lock  sync.Mutex
+	using map[string]int
+}
+
+var pool = &accountPool{
+	using: map[string]int{},
+}
+
+// cookieOverrides ReplaceCookie替换后的账号cookie，只保存在内存中，配置中的账号不会被修改
+var cookieOverrides = struct {
+	lock    sync.RWMutex
+	cookies map[string]string
+}{cookies: map[string]string{}}
+
+// Account 返回账号池中的账号，cookie被替换过时使用替换后的cookie
+func Account(name string) (config.Account, bool) {
+	account, ok := config.Get().Poe.Accounts[name]
+	if !ok {
+		return account, false
+	}
+	cookieOverrides.lock.RLock()
+	defer cookieOverrides.lock.RUnlock()
+	if cookie, ok := cookieOverrides.cookies[name]; ok {
+		account.Cookie = cookie
+	}
+	return account, true
+}
+
+func setAccountCookie(name string, cookie string) {
+	cookieOverrides.lock.Lock()
+	defer cookieOverrides.lock.Unlock()
+	cookieOverrides.cookies[name] = cookie
+}
+
+// acquire 返回记录使用的账号名和cookie，记录直接配置cookie时账号名为空
+// 记录没有指定账号时，从账号池中分配使用者最少的账号
+func (p *accountPool) acquire(r *dao.Record) (string, string, error) {
+	if r.Cookie != "" {
+		return "", r.Cookie, nil
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if r.Account != "" {
+		account, ok := Account(r.Account)
+		if !ok {
+			return "", "", fmt.Errorf("unknown account %s", r.Account)
+		}
+		p.using[r.Account]++
+		return r.Account, account.Cookie, nil
+	}
+
+	names := make([]string, 0, len(config.Get().Poe.Accounts))
+	for name := range config.Get().Poe.Accounts {
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return "", "", fmt.Errorf("record %d has no cookie and account pool is empty", r.ID)
+	}
+	sort.Strings(names)
+	name := names[0]
+	for _, n := range names[1:] {
+		if p.using[n] < p.using[name] {
+			name = n
+		}
+	}
+	p.using[name]++
+	account, _ := Account(name)
+	return name, account.Cookie, nil
+}
+
+// resolveProxy 优先使用记录的代理，其次是账号的代理，最后是全局代理
+func resolveProxy(r *dao.Record, account string) string {
+	if r.Proxy != "" {
+		return r.Proxy
+	}
+	if a, ok := Account(account); ok && a.Proxy != "" {
+		return a.Proxy
+	}
+	return config.Get().Poe.Proxy
+}
+
+func (p *accountPool) release(name string) {
+	if name == "" {
+		return
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.using[name] > 0 {
+		p.using[name]--
+	}
+}
@@ -0,0 +1,66 @@
+package watch
+
+import (
+	"time"
+
+	"github.com/ink19/poewatcher/config"
+	"github.com/ink19/poewatcher/logic/poetrader"
+)
+
+const defaultBatchWindow = 500 * time.Millisecond
+
+func batchWindow() time.Duration {
+	if v := config.Get().Poe.BatchWindow; v > 0 {
+		return time.Duration(v) * time.Millisecond
+	}
+	return defaultBatchWindow
+}
+
+// batchGoods 把收到的物品ID按时间窗口聚合，每批最多size个
+// 第一个ID到达时开始计时，窗口结束或者攒满时发出
+func batchGoods(in <-chan *poetrader.PoeGood, window time.Duration, size int) <-chan []string {
+	out := make(chan []string)
+	go func() {
+		defer close(out)
+
+		var (
+			ids   []string
+			timer *time.Timer
+			timeC <-chan time.Time
+		)
+		flush := func() {
+			if timer != nil {
+				timer.Stop()
+				timer, timeC = nil, nil
+			}
+			if len(ids) == 0 {
+				return
+			}
+			out <- ids
+			ids = nil
+		}
+
+		for {
+			select {
+			case good, ok := <-in:
+				if !ok {
+					flush()
+					return
+				}
+				ids = append(ids, good.ID)
+				if len(ids) >= size {
+					flush()
+					break
+				}
+				if timer == nil {
+					timer = time.NewTimer(window)
+					timeC = timer.C
+				}
+			case <-timeC:
+				timer, timeC = nil, nil
+				flush()
+			}
+		}
+	}()
+	return out
+}
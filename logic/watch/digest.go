@@ -0,0 +1,237 @@
+package watch
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ink19/poewatcher/logic/dao"
+	"github.com/ink19/poewatcher/logic/poetrader"
+	"github.com/ink19/poewatcher/logic/pricing"
+	"github.com/ink19/poewatcher/pkg/notify"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultDigestWindow = time.Minute
+	defaultDigestFull   = 3
+)
+
+type digestHit struct {
+	// record 物品到达时记录的副本，发送在计时器协程中进行，不能读取监控协程会修改的记录
+	record *dao.Record
+	good   *poetrader.PoeGood
+	value  *pricing.Value
+}
+
+// digest 汇总一段时间内的物品，合并为一条按价格排序的消息
+type digest struct {
+	w        *watcher
+	notifier notify.Notifier
+	window   time.Duration
+	max      int
+	full     int
+
+	lock  sync.Mutex
+	hits  []*digestHit
+	timer *time.Timer
+	// sendLock 保证消息依次发送，close时等待正在发送的消息
+	sendLock sync.Mutex
+}
+
+// newDigest 记录没有设置汇总时返回nil
+func newDigest(w *watcher, notifier notify.Notifier, param *dao.DigestParam) *digest {
+	if param == nil {
+		return nil
+	}
+	d := &digest{
+		w:        w,
+		notifier: notifier,
+		window:   defaultDigestWindow,
+		max:      param.Max,
+		full:     defaultDigestFull,
+	}
+	if param.Window > 0 {
+		d.window = time.Duration(param.Window) * time.Second
+	}
+	if param.Full > 0 {
+		d.full = param.Full
+	}
+	return d
+}
+
+// ValidateDigest 添加记录时检查汇总参数
+func ValidateDigest(param *dao.DigestParam) error {
+	if param == nil {
+		return nil
+	}
+	if param.Window < 0 || param.Max < 0 || param.Full < 0 {
+		return fmt.Errorf("digest window, max and full must not be negative")
+	}
+	return nil
+}
+
+// add 第一个物品到达时开始计时，攒满max个时立即发送
+func (d *digest) add(good *poetrader.PoeGood, value *pricing.Value) {
+	record := d.w.Record()
+	d.lock.Lock()
+	// 发送前不会标记，同一物品可能再次出现
+	for _, hit := range d.hits {
+		if hit.good.ID == good.ID {
+			d.lock.Unlock()
+			return
+		}
+	}
+	d.hits = append(d.hits, &digestHit{record: record, good: good, value: value})
+	full := d.max > 0 && len(d.hits) >= d.max
+	if !full && d.timer == nil {
+		d.timer = time.AfterFunc(d.window, func() {
+			d.flush(context.Background())
+		})
+	}
+	d.lock.Unlock()
+
+	if full {
+		d.flush(context.Background())
+	}
+}
+
+func (d *digest) flush(ctx context.Context) {
+	d.sendLock.Lock()
+	defer d.sendLock.Unlock()
+
+	d.lock.Lock()
+	hits := d.hits
+	d.hits = nil
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+	d.lock.Unlock()
+
+	switch len(hits) {
+	case 0:
+		return
+	case 1:
+		if d.w.notifyGood(ctx, d.notifier, hits[0].record, hits[0].good, hits[0].value) {
+			d.w.markSeen(ctx, []string{hits[0].good.ID})
+		}
+		return
+	}
+
+	sortHits(hits)
+	msg := d.render(ctx, hits)
+	logrus.WithContext(ctx).Debugf("record %d digest %d goods", d.w.record.ID, len(hits))
+	d.w.saveLastHit(ctx, hits[0].good)
+	if err := d.notifier.Send(ctx, msg); err != nil {
+		logrus.WithContext(ctx).Errorf("Send digest fail, err: %v", err)
+		return
+	}
+	ids := make([]string, 0, len(hits))
+	for _, hit := range hits {
+		ids = append(ids, hit.good.ID)
+	}
+	d.w.markSeen(ctx, ids)
+}
+
+// close 发送剩余的物品，可以在nil上调用
+func (d *digest) close() {
+	if d == nil {
+		return
+	}
+	d.flush(context.Background())
+}
+
+// render 价格最低的full个物品使用模板显示完整内容，其余每个一行
+func (d *digest) render(ctx context.Context, hits []*digestHit) *notify.Message {
+	text := &strings.Builder{}
+	markdown := &strings.Builder{}
+	name := hits[0].record.Name
+	fmt.Fprintf(text, "%s 汇总: %d 个物品", name, len(hits))
+	fmt.Fprintf(markdown, "**%s** 汇总: %d 个物品", name, len(hits))
+
+	shown := 0
+	for _, hit := range hits {
+		if shown >= d.full {
+			break
+		}
+		msg, err := d.w.renderGood(ctx, hit.record, hit.good, hit.value)
+		if err != nil {
+			break
+		}
+		text.WriteString("\n========\n" + msg.Text)
+		markdown.WriteString("\n\n---\n" + msg.Markdown)
+		shown++
+	}
+	rest := hits[shown:]
+	if len(rest) == 0 {
+		return &notify.Message{Text: text.String(), Markdown: markdown.String()}
+	}
+
+	fmt.Fprintf(text, "\n========\n其他 %d 个:", len(rest))
+	fmt.Fprintf(markdown, "\n\n---\n其他 %d 个:", len(rest))
+	for _, hit := range rest {
+		line := hitLine(hit)
+		text.WriteString("\n" + line)
+		markdown.WriteString("\n> " + line)
+	}
+	return &notify.Message{Text: text.String(), Markdown: markdown.String()}
+}
+
+// hitLine 物品名称、标价、折算价格和卖家
+func hitLine(hit *digestHit) string {
+	it := describeItem(&hit.good.Item)
+	parts := []string{it.Base}
+	if it.Name != "" && it.Name != it.Base {
+		parts = []string{it.Name + " " + it.Base}
+	}
+	if p := hit.good.Listing.Price; p != nil {
+		parts = append(parts, fmt.Sprintf("%v %s", p.Amount, p.Currency))
+	}
+	if hit.value != nil {
+		parts = append(parts, "≈ "+hit.value.String())
+	}
+	if a := hit.good.Listing.Account; a != nil {
+		seller := a.LastCharacterName
+		if seller == "" {
+			seller = a.Name
+		}
+		parts = append(parts, "@"+seller)
+	}
+	return strings.Join(parts, " ")
+}
+
+// sortHits 按折算后的价格从低到高排序，无法折算的物品排在后面，按通货分组后比较标价，没有标价的排在最后
+func sortHits(hits []*digestHit) {
+	sort.SliceStable(hits, func(i, j int) bool {
+		ri, rj := hitRank(hits[i]), hitRank(hits[j])
+		if ri != rj {
+			return ri < rj
+		}
+		switch ri {
+		case 0:
+			return hits[i].value.Chaos < hits[j].value.Chaos
+		case 1:
+			pi, pj := hits[i].good.Listing.Price, hits[j].good.Listing.Price
+			if pi.Currency != pj.Currency {
+				return pi.Currency < pj.Currency
+			}
+			return pi.Amount < pj.Amount
+		}
+		return false
+	})
+}
+
+// hitRank 0 可以折算，1 只有标价，2 没有标价
+func hitRank(hit *digestHit) int {
+	switch {
+	case hit.value != nil:
+		return 0
+	case hit.good.Listing.Price != nil:
+		return 1
+	}
+	return 2
+}
@@ -0,0 +1,133 @@
+package watch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ink19/poewatcher/config"
+	"github.com/ink19/poewatcher/logic/dao"
+	"github.com/ink19/poewatcher/logic/poetrader"
+	"github.com/ink19/poewatcher/logic/pricing"
+	"github.com/ink19/poewatcher/logic/tradedata"
+	"github.com/ink19/poewatcher/pkg/notify"
+	"github.com/sirupsen/logrus"
+)
+
+const defaultExchangeInterval = time.Minute
+
+func exchangeInterval() time.Duration {
+	if v := config.Get().Poe.ExchangeInterval; v > 0 {
+		return time.Duration(v) * time.Second
+	}
+	return defaultExchangeInterval
+}
+
+// WatchExchange 轮询通货兑换，报价优于记录中的比例时通知
+func (w *watcher) WatchExchange(ctx context.Context) error {
+	param := w.record.Exchange
+	if param == nil || len(param.Have) == 0 || len(param.Want) == 0 {
+		err := fmt.Errorf("record %d has no exchange param", w.record.ID)
+		logrus.WithContext(ctx).Errorf("%v", err)
+		return err
+	}
+
+	notifyClient, err := newNotifier(w.record.Notifiers)
+	if err != nil {
+		return err
+	}
+	poeClient, err := w.newClient()
+	if err != nil {
+		logrus.WithContext(ctx).Errorf("newClient fail, err: %v", err)
+		return err
+	}
+	defer w.releaseClient()
+	w.transit(ctx, StatePolling, nil)
+	query := &poetrader.ExchangeQuery{
+		Have:    param.Have,
+		Want:    param.Want,
+		Minimum: param.Minimum,
+	}
+
+	// 上一轮已经通知过的报价，避免每次轮询重复通知
+	notified := map[string]struct{}{}
+	ticker := time.NewTicker(exchangeInterval())
+	defer ticker.Stop()
+	for {
+		res, err := poeClient.Exchange(ctx, query)
+		if poetrader.IsFatal(err) || errors.Is(err, poetrader.ErrBadRequest) {
+			logrus.WithContext(ctx).Errorf("Exchange fail, stop poll, err: %v", err)
+			return err
+		}
+		if err != nil {
+			logrus.WithContext(ctx).Errorf("Exchange fail, err: %v", err)
+		} else {
+			notified = w.notifyExchange(ctx, notifyClient, param, res, notified)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		// 限频时等到解除后再轮询
+		if retryAfter := poetrader.RetryAfter(err); retryAfter > 0 {
+			logrus.WithContext(ctx).Warnf("Exchange rate limited, pause %s", retryAfter)
+			timer := time.NewTimer(retryAfter)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return nil
+			case <-timer.C:
+			}
+		}
+	}
+}
+
+func (w *watcher) notifyExchange(ctx context.Context, notifyClient notify.Notifier, param *dao.ExchangeParam, res *poetrader.ExchangeRes, notified map[string]struct{}) map[string]struct{} {
+	current := map[string]struct{}{}
+	for _, result := range res.Result {
+		if result == nil || result.Listing == nil {
+			continue
+		}
+		for _, offer := range result.Listing.Offers {
+			ratio := offer.Ratio()
+			if ratio <= 0 || ratio > param.Ratio {
+				continue
+			}
+			key := fmt.Sprintf("%s:%v:%v", result.ID, offer.Exchange.Amount, offer.Item.Amount)
+			if _, ok := notified[key]; ok {
+				current[key] = struct{}{}
+				continue
+			}
+			msg := formatExchangeMsg(ctx, w.record, result.Listing, offer)
+			logrus.WithContext(ctx).Debugf("%s", msg)
+			// 发送失败时不记录，下一轮轮询重新通知
+			if err := notifyClient.Send(ctx, &notify.Message{Text: msg}); err != nil {
+				logrus.WithContext(ctx).Errorf("Send fail, err: %v", err)
+				continue
+			}
+			current[key] = struct{}{}
+		}
+	}
+	return current
+}
+
+func formatExchangeMsg(ctx context.Context, record *dao.Record, listing *poetrader.ExchangeListing, offer *poetrader.ExchangeOffer) string {
+	sb := strings.Builder{}
+	sb.WriteString(fmt.Sprintf("【%s】\n", record.Name))
+	sb.WriteString(fmt.Sprintf("%v %s => %v %s\n",
+		offer.Exchange.Amount, tradedata.CurrencyName(ctx, record.Realm, offer.Exchange.Currency),
+		offer.Item.Amount, tradedata.CurrencyName(ctx, record.Realm, offer.Item.Currency)))
+	sb.WriteString(fmt.Sprintf("比例: %.2f, 库存: %d\n", offer.Ratio(), offer.Item.Stock))
+	if v, err := pricing.Normalize(ctx, record.SeasonID, offer.Ratio(), offer.Exchange.Currency); err == nil {
+		sb.WriteString(fmt.Sprintf("单价折合: %s\n", v))
+	}
+	if listing.Account != nil {
+		sb.WriteString(fmt.Sprintf("卖家: %s\n", listing.Account.LastCharacterName))
+	}
+	sb.WriteString(listing.OfferWhisper(offer))
+	return sb.String()
+}
@@ -0,0 +1,246 @@
+package watch
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/ink19/poewatcher/logic/poetrader"
+	"github.com/ink19/poewatcher/logic/pricing"
+	"github.com/ink19/poewatcher/logic/tradedata"
+	"github.com/ink19/poewatcher/pkg/filter"
+	"github.com/ink19/poewatcher/pkg/itemtext"
+	"github.com/sirupsen/logrus"
+)
+
+// compileFilter 解析并检查过滤表达式，为空时返回nil
+func compileFilter(src string) (*filter.Expr, error) {
+	if strings.TrimSpace(src) == "" {
+		return nil, nil
+	}
+	expr, err := filter.Parse(src)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter: %w", err)
+	}
+	if err := expr.Check(goodEnv(context.Background(), "", &poetrader.PoeGood{}, nil)); err != nil {
+		return nil, fmt.Errorf("invalid filter: %w", err)
+	}
+	return expr, nil
+}
+
+// ValidateFilter 添加记录时检查过滤表达式的语法和变量名
+func ValidateFilter(src string) error {
+	_, err := compileFilter(src)
+	return err
+}
+
+// applyFilter 返回满足过滤表达式的物品，求值出错时保留物品，宁可多通知也不漏掉
+func (w *watcher) applyFilter(ctx context.Context, goods []*poetrader.PoeGood, values map[string]*pricing.Value) []*poetrader.PoeGood {
+	if w.filter == nil {
+		w.countHits(len(goods), 0)
+		return goods
+	}
+	res := make([]*poetrader.PoeGood, 0, len(goods))
+	for _, good := range goods {
+		ok, err := w.filter.Match(goodEnv(ctx, w.record.Realm, good, values[good.ID]))
+		if err != nil {
+			logrus.WithContext(ctx).Warnf("record %d filter %s fail, keep it, err: %v", w.record.ID, good.ID, err)
+			ok = true
+		}
+		if ok {
+			res = append(res, good)
+		}
+	}
+	if dropped := len(goods) - len(res); dropped > 0 {
+		logrus.WithContext(ctx).Infof("record %d filter dropped %d of %d goods", w.record.ID, dropped, len(goods))
+	}
+	w.countHits(len(goods), len(goods)-len(res))
+	return res
+}
+
+func (w *watcher) countHits(hits int, dropped int) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.status.Hits += int64(hits)
+	w.status.Dropped += int64(dropped)
+}
+
+// describeItem 合并接口返回的字段和复制文本的解析结果，接口缺少的字段用文本补充
+func describeItem(item *poetrader.PoeItem) *itemtext.Item {
+	var parsed *itemtext.Item
+	if desc, err := base64.StdEncoding.DecodeString(item.Extended.DescText); err == nil && len(desc) > 0 {
+		parsed, _ = itemtext.Parse(string(desc))
+	}
+	if item.TypeLine == "" && parsed != nil {
+		return parsed
+	}
+
+	it := &itemtext.Item{
+		Rarity:       itemtext.ParseRarity(item.Rarity),
+		Name:         item.Name,
+		Base:         item.BaseType,
+		ItemLevel:    item.ItemLevel,
+		Sockets:      item.SocketString(),
+		Links:        item.Links(),
+		Influences:   item.InfluenceList(),
+		Corrupted:    item.Corrupted,
+		Mirrored:     item.Mirrored,
+		Unidentified: !item.Identified,
+		Note:         item.Note,
+	}
+	if it.Name == "" {
+		it.Name = item.TypeLine
+	}
+	if it.Base == "" {
+		it.Base = item.TypeLine
+	}
+	addMods := func(kind itemtext.ModKind, mods []string) []*itemtext.Mod {
+		res := make([]*itemtext.Mod, 0, len(mods))
+		for _, m := range mods {
+			res = append(res, itemtext.NewMod(kind, m))
+		}
+		return res
+	}
+	it.Enchants = addMods(itemtext.ModEnchant, item.EnchantMods)
+	it.Implicits = addMods(itemtext.ModImplicit, item.ImplicitMods)
+	it.Explicits = addMods(itemtext.ModExplicit, item.ExplicitMods)
+	it.Crafted = addMods(itemtext.ModCrafted, item.CraftedMods)
+	it.Fractured = addMods(itemtext.ModFractured, item.FracturedMods)
+
+	if parsed == nil {
+		return it
+	}
+	it.Class = parsed.Class
+	it.Quality = parsed.Quality
+	it.Requirements = parsed.Requirements
+	it.Properties = parsed.Properties
+	if it.Rarity == itemtext.RarityUnknown {
+		it.Rarity = parsed.Rarity
+	}
+	if it.ItemLevel == 0 {
+		it.ItemLevel = parsed.ItemLevel
+	}
+	if len(it.Influences) == 0 {
+		it.Influences = parsed.Influences
+	}
+	if len(it.Mods()) == 0 {
+		it.Enchants, it.Implicits, it.Explicits, it.Crafted, it.Fractured =
+			parsed.Enchants, parsed.Implicits, parsed.Explicits, parsed.Crafted, parsed.Fractured
+	}
+	return it
+}
+
+// statIDRe 交易站点的词缀ID，比如 explicit.stat_3299347043
+var statIDRe = regexp.MustCompile(`^[a-z]+\.stat_\d+$`)
+
+// matchMods 参数为词缀ID时先转换为交易站点的词缀文本
+// 模板中含有#时匹配模板，否则匹配原文，都不区分大小写
+func matchMods(ctx context.Context, realm string, it *itemtext.Item, args []interface{}) ([]*itemtext.Mod, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("expect 1 argument, got %d", len(args))
+	}
+	pattern, ok := args[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("expect string argument, got %T", args[0])
+	}
+	if statIDRe.MatchString(pattern) {
+		pattern = tradedata.StatText(ctx, realm, pattern)
+	}
+	pattern = strings.ToLower(pattern)
+	res := []*itemtext.Mod{}
+	for _, mod := range it.Mods() {
+		text := mod.Text
+		if strings.Contains(pattern, "#") {
+			text = mod.Template
+		}
+		if strings.Contains(strings.ToLower(text), pattern) {
+			res = append(res, mod)
+		}
+	}
+	return res, nil
+}
+
+// goodEnv 过滤表达式可以使用的变量和函数
+// mod(p) 第一个匹配词缀的第一个数值，没有时为null；mods(p) 所有匹配词缀第一个数值之和；has(p) 是否有匹配的词缀
+// p可以是词缀文本、含有#的模板或者词缀ID
+func goodEnv(ctx context.Context, realm string, good *poetrader.PoeGood, value *pricing.Value) filter.Env {
+	it := describeItem(&good.Item)
+	modTexts := []string{}
+	for _, mod := range it.Mods() {
+		modTexts = append(modTexts, mod.Text)
+	}
+
+	price := filter.Env{"amount": nil, "currency": nil, "chaos": nil, "divine": nil}
+	if p := good.Listing.Price; p != nil {
+		price["amount"] = p.Amount
+		price["currency"] = p.Currency
+		if p.Currency == pricing.CurrencyChaos {
+			price["chaos"] = p.Amount
+		}
+	}
+	if value != nil {
+		price["chaos"] = value.Chaos
+		if value.Divine > 0 {
+			price["divine"] = value.Divine
+		}
+	}
+
+	seller := filter.Env{"name": "", "character": "", "online": false, "status": ""}
+	if a := good.Listing.Account; a != nil {
+		seller["name"] = a.Name
+		seller["character"] = a.LastCharacterName
+		if a.Online != nil {
+			seller["online"] = true
+			seller["status"] = a.Online.Status
+		}
+	}
+
+	return filter.Env{
+		"item": filter.Env{
+			"name":             it.Name,
+			"base":             it.Base,
+			"class":            it.Class,
+			"rarity":           string(it.Rarity),
+			"ilvl":             it.ItemLevel,
+			"quality":          it.Quality,
+			"level":            it.Requirements.Level,
+			"sockets":          it.Sockets,
+			"links":            it.Links,
+			"corrupted":        it.Corrupted,
+			"mirrored":         it.Mirrored,
+			"identified":       !it.Unidentified,
+			"influences":       it.Influences,
+			"note":             it.Note,
+			"stack_size":       good.Item.StackSize,
+			"mods":             modTexts,
+			"resistance":       it.ElementalResistance(),
+			"chaos_resistance": it.ChaosResistance(),
+			"life":             it.MaximumLife(),
+		},
+		"price":  price,
+		"seller": seller,
+		"mod": filter.Func(func(args ...interface{}) (interface{}, error) {
+			mods, err := matchMods(ctx, realm, it, args)
+			if err != nil || len(mods) == 0 || len(mods[0].Values) == 0 {
+				return nil, err
+			}
+			return mods[0].Values[0], nil
+		}),
+		"mods": filter.Func(func(args ...interface{}) (interface{}, error) {
+			mods, err := matchMods(ctx, realm, it, args)
+			total := 0.0
+			for _, mod := range mods {
+				if len(mod.Values) > 0 {
+					total += mod.Values[0]
+				}
+			}
+			return total, err
+		}),
+		"has": filter.Func(func(args ...interface{}) (interface{}, error) {
+			mods, err := matchMods(ctx, realm, it, args)
+			return len(mods) > 0, err
+		}),
+	}
+}
@@ -0,0 +1,116 @@
+package watch
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/ink19/poewatcher/config"
+	"github.com/ink19/poewatcher/pkg/notify"
+)
+
+// NotifierInfo 通知目标的名称、类型和格式，不包含URL中的密钥
+type NotifierInfo struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Format  string `json:"format"`
+	Default bool   `json:"default"`
+}
+
+// Notifiers 返回配置中的通知目标
+func Notifiers() []NotifierInfo {
+	cfg := config.Get().Notify
+	res := make([]NotifierInfo, 0, len(cfg.Targets))
+	for name, target := range cfg.Targets {
+		typ := target.Type
+		if typ == "" {
+			typ = notify.TypeWxWork
+		}
+		format := target.Format
+		if format == "" {
+			format = string(notify.FormatText)
+		}
+		res = append(res, NotifierInfo{Name: name, Type: typ, Format: format, Default: name == cfg.Default})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res
+}
+
+// ValidateNotifiers 检查记录选择的通知目标都在配置中
+func ValidateNotifiers(names []string) error {
+	_, err := newNotifier(names)
+	return err
+}
+
+func newTarget(target config.NotifyTarget) (notify.Notifier, error) {
+	c, err := notify.New(target.Type, target.URL)
+	if err != nil {
+		return nil, err
+	}
+	format, err := notify.ParseFormat(target.Format)
+	if err != nil {
+		return nil, err
+	}
+	return notify.NewTarget(c, format), nil
+}
+
+// newNotifier 按名称生成通知客户端，多个目标时依次发送，没有选择时使用默认目标
+func newNotifier(names []string) (notify.Notifier, error) {
+	cfg := config.Get().Notify
+	if len(names) == 0 {
+		if cfg.Default == "" {
+			return newTarget(config.NotifyTarget{Type: cfg.Type, URL: cfg.URL, Format: cfg.Format})
+		}
+		names = []string{cfg.Default}
+	}
+	clients := make([]notify.Notifier, 0, len(names))
+	seen := map[string]struct{}{}
+	for _, name := range names {
+		if _, ok := seen[name]; ok {
+			continue
+		}
+		seen[name] = struct{}{}
+		target, ok := cfg.Targets[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown notifier %s", name)
+		}
+		c, err := newTarget(target)
+		if err != nil {
+			return nil, fmt.Errorf("notifier %s: %w", name, err)
+		}
+		clients = append(clients, c)
+	}
+	return notify.NewMulti(clients...), nil
+}
+
+// notifierForRecords 发送到所有记录选择的目标，每个目标只发送一次
+func notifierForRecords(ws []Watcher) (notify.Notifier, error) {
+	names := []string{}
+	useDefault := false
+	for _, w := range ws {
+		if len(w.Record().Notifiers) == 0 {
+			useDefault = true
+			continue
+		}
+		names = append(names, w.Record().Notifiers...)
+	}
+	if useDefault && config.Get().Notify.Default != "" {
+		names = append(names, config.Get().Notify.Default)
+		useDefault = false
+	}
+	clients := []notify.Notifier{}
+	if useDefault || len(names) == 0 {
+		c, err := newNotifier(nil)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, c)
+	}
+	if len(names) > 0 {
+		c, err := newNotifier(names)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, c)
+	}
+	return notify.NewMulti(clients...), nil
+}
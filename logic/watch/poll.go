@@ -0,0 +1,111 @@
+package watch
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ink19/poewatcher/config"
+	"github.com/ink19/poewatcher/logic/dao"
+	"github.com/ink19/poewatcher/logic/poetrader"
+	"github.com/ink19/poewatcher/pkg/notify"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultPollInterval      = time.Minute
+	defaultLiveRetryInterval = 10 * time.Minute
+	// pollSeenTTL 超过该时间没有出现在结果中的ID不再记录
+	pollSeenTTL = time.Hour
+)
+
+func pollInterval() time.Duration {
+	if v := config.Get().Poe.PollInterval; v > 0 {
+		return time.Duration(v) * time.Second
+	}
+	return defaultPollInterval
+}
+
+func liveRetryInterval() time.Duration {
+	if v := config.Get().Poe.LiveRetryInterval; v > 0 {
+		return time.Duration(v) * time.Minute
+	}
+	return defaultLiveRetryInterval
+}
+
+// pollSearch 定时重新搜索，把新出现的ID交给获取详情和通知的流程，直到ctx取消
+// 只比较第一页结果，查询需要按上架时间排序才能发现所有新物品
+func (w *watcher) pollSearch(ctx context.Context, c poetrader.Client, notifyClient notify.Notifier) error {
+	w.transit(ctx, StatePolling, nil)
+	ticker := time.NewTicker(pollInterval())
+	defer ticker.Stop()
+	for {
+		newIDs, err := w.pollNewIDs(ctx, c)
+		if poetrader.IsFatal(err) || errors.Is(err, poetrader.ErrBadRequest) {
+			logrus.WithContext(ctx).Errorf("Poll search fail, stop poll, err: %v", err)
+			return err
+		}
+		if err != nil {
+			logrus.WithContext(ctx).Errorf("Poll search fail, err: %v", err)
+		}
+		if len(newIDs) > 0 {
+			logrus.WithContext(ctx).Infof("record %d poll got %d new items", w.record.ID, len(newIDs))
+			ch := make(chan *poetrader.PoeGood, len(newIDs))
+			for _, id := range newIDs {
+				ch <- &poetrader.PoeGood{ID: id}
+			}
+			close(ch)
+			if err := w.consumeGoods(c, ch, notifyClient, nil); err != nil {
+				return err
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		// 限频时等到解除后再轮询
+		if retryAfter := poetrader.RetryAfter(err); retryAfter > 0 {
+			logrus.WithContext(ctx).Warnf("Poll search rate limited, pause %s", retryAfter)
+			timer := time.NewTimer(retryAfter)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return nil
+			case <-timer.C:
+			}
+		}
+	}
+}
+
+// pollNewIDs 搜索一次，返回之前没有见过的ID，第一次搜索只记录不通知
+func (w *watcher) pollNewIDs(ctx context.Context, c poetrader.Client) ([]string, error) {
+	res, err := c.Search(ctx, w.record.Query)
+	if err != nil {
+		return nil, err
+	}
+	// 获取详情时使用最新的搜索ID
+	w.updateRecord(func(r *dao.Record) {
+		r.SearchID = res.ID
+	})
+
+	now := time.Now()
+	first := w.pollSeen == nil
+	if first {
+		w.pollSeen = map[string]time.Time{}
+	}
+	newIDs := []string{}
+	for _, id := range res.Result {
+		if _, ok := w.pollSeen[id]; !ok && !first {
+			newIDs = append(newIDs, id)
+		}
+		w.pollSeen[id] = now
+	}
+	for id, seenAt := range w.pollSeen {
+		if now.Sub(seenAt) > pollSeenTTL {
+			delete(w.pollSeen, id)
+		}
+	}
+	return newIDs, nil
+}
@@ -0,0 +1,243 @@
+package watch
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ink19/poewatcher/config"
+	"github.com/ink19/poewatcher/logic/dao"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultLiveLimit          = 20
+	defaultLiveRotateInterval = 10 * time.Minute
+)
+
+func liveLimit(key string) int {
+	if account, ok := Account(key); ok && account.LiveLimit > 0 {
+		return account.LiveLimit
+	}
+	if v := config.Get().Poe.LiveLimit; v > 0 {
+		return v
+	}
+	return defaultLiveLimit
+}
+
+func liveRotateInterval() time.Duration {
+	if v := config.Get().Poe.LiveRotateInterval; v > 0 {
+		return time.Duration(v) * time.Minute
+	}
+	return defaultLiveRotateInterval
+}
+
+type slotRequest struct {
+	record       *dao.Record
+	waitingSince time.Time
+	activeSince  time.Time
+
+	granted chan struct{}
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+type accountSlots struct {
+	active  map[*slotRequest]struct{}
+	waiting map[*slotRequest]struct{}
+}
+
+// liveScheduler 控制每个账号同时打开的直播搜索连接数
+// 连接数不足时按优先级分配，同优先级及更低优先级的记录定时轮换
+type liveScheduler struct {
+	lock     sync.Mutex
+	accounts map[string]*accountSlots
+	once     *sync.Once
+}
+
+var scheduler = &liveScheduler{
+	accounts: map[string]*accountSlots{},
+	once:     &sync.Once{},
+}
+
+type SlotState struct {
+	Limit   int     `json:"limit"`
+	Active  []int64 `json:"active"`
+	Waiting []int64 `json:"waiting"`
+}
+
+// LiveSlotState 返回每个账号正在监听和等待中的记录
+func LiveSlotState() map[string]SlotState {
+	return scheduler.state()
+}
+
+// acquire 阻塞直到分配到连接，返回的ctx在连接被收回时取消
+// 不能立即分配时，waiting不为nil则在等待期间执行，分配到连接或者ctx取消后等待其退出
+func (s *liveScheduler) acquire(ctx context.Context, key string, record *dao.Record, waiting func(ctx context.Context)) (context.Context, func(), error) {
+	s.once.Do(func() {
+		go s.rotate()
+	})
+
+	slotCtx, cancel := context.WithCancel(ctx)
+	req := &slotRequest{
+		record:       record,
+		waitingSince: time.Now(),
+		granted:      make(chan struct{}),
+		ctx:          slotCtx,
+		cancel:       cancel,
+	}
+
+	s.lock.Lock()
+	slots, ok := s.accounts[key]
+	if !ok {
+		slots = &accountSlots{
+			active:  map[*slotRequest]struct{}{},
+			waiting: map[*slotRequest]struct{}{},
+		}
+		s.accounts[key] = slots
+	}
+	slots.waiting[req] = struct{}{}
+	s.schedule(key, slots)
+	s.lock.Unlock()
+
+	release := func() {
+		cancel()
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		delete(slots.waiting, req)
+		delete(slots.active, req)
+		s.schedule(key, slots)
+	}
+
+	select {
+	case <-req.granted:
+		return slotCtx, release, nil
+	default:
+	}
+	if waiting != nil {
+		waitCtx, stopWaiting := context.WithCancel(ctx)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			waiting(waitCtx)
+		}()
+		defer func() {
+			stopWaiting()
+			<-done
+		}()
+	}
+
+	select {
+	case <-req.granted:
+		return slotCtx, release, nil
+	case <-ctx.Done():
+		release()
+		return nil, nil, ctx.Err()
+	}
+}
+
+// schedule 填充空闲连接，没有空闲时让高优先级的记录抢占最低优先级的连接
+func (s *liveScheduler) schedule(key string, slots *accountSlots) {
+	limit := liveLimit(key)
+	for _, req := range sortedWaiting(slots) {
+		if len(slots.active) >= limit {
+			victim := lowestActive(slots, req.record.Priority-1, 0)
+			if victim == nil {
+				return
+			}
+			s.revoke(key, slots, victim)
+		}
+		s.grant(slots, req)
+	}
+}
+
+// rotate 定时让等待中的记录轮换掉运行足够久、优先级不高于自己的记录
+func (s *liveScheduler) rotate() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.lock.Lock()
+		for key, slots := range s.accounts {
+			limit := liveLimit(key)
+			for _, req := range sortedWaiting(slots) {
+				if len(slots.active) < limit {
+					s.grant(slots, req)
+					continue
+				}
+				victim := lowestActive(slots, req.record.Priority, liveRotateInterval())
+				if victim == nil {
+					continue
+				}
+				s.revoke(key, slots, victim)
+				s.grant(slots, req)
+			}
+		}
+		s.lock.Unlock()
+	}
+}
+
+func (s *liveScheduler) grant(slots *accountSlots, req *slotRequest) {
+	delete(slots.waiting, req)
+	slots.active[req] = struct{}{}
+	req.activeSince = time.Now()
+	close(req.granted)
+}
+
+func (s *liveScheduler) revoke(key string, slots *accountSlots, req *slotRequest) {
+	logrus.Infof("account %s live slot of record %d revoked", key, req.record.ID)
+	delete(slots.active, req)
+	req.cancel()
+}
+
+func sortedWaiting(slots *accountSlots) []*slotRequest {
+	res := make([]*slotRequest, 0, len(slots.waiting))
+	for req := range slots.waiting {
+		res = append(res, req)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].record.Priority != res[j].record.Priority {
+			return res[i].record.Priority > res[j].record.Priority
+		}
+		return res[i].waitingSince.Before(res[j].waitingSince)
+	})
+	return res
+}
+
+// lowestActive 返回优先级不高于maxPriority、运行时间不少于minActive的连接中优先级最低、运行最久的
+func lowestActive(slots *accountSlots, maxPriority int, minActive time.Duration) *slotRequest {
+	var victim *slotRequest
+	for req := range slots.active {
+		if req.record.Priority > maxPriority || time.Since(req.activeSince) < minActive {
+			continue
+		}
+		if victim == nil || req.record.Priority < victim.record.Priority ||
+			(req.record.Priority == victim.record.Priority && req.activeSince.Before(victim.activeSince)) {
+			victim = req
+		}
+	}
+	return victim
+}
+
+func (s *liveScheduler) state() map[string]SlotState {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	res := map[string]SlotState{}
+	for key, slots := range s.accounts {
+		state := SlotState{
+			Limit:   liveLimit(key),
+			Active:  []int64{},
+			Waiting: []int64{},
+		}
+		for req := range slots.active {
+			state.Active = append(state.Active, req.record.ID)
+		}
+		for _, req := range sortedWaiting(slots) {
+			state.Waiting = append(state.Waiting, req.record.ID)
+		}
+		sort.Slice(state.Active, func(i, j int) bool { return state.Active[i] < state.Active[j] })
+		res[key] = state
+	}
+	return res
+}
@@ -0,0 +1,248 @@
+package watch
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ink19/poewatcher/config"
+	"github.com/ink19/poewatcher/logic/dao"
+	"github.com/ink19/poewatcher/logic/poetrader"
+	"github.com/ink19/poewatcher/pkg/notify"
+	"github.com/sirupsen/logrus"
+)
+
+const defaultSessionCheckInterval = 30 * time.Minute
+
+func sessionCheckInterval() time.Duration {
+	if v := config.Get().Poe.SessionCheckInterval; v > 0 {
+		return time.Duration(v) * time.Minute
+	}
+	return defaultSessionCheckInterval
+}
+
+type SessionState struct {
+	Key         string    `json:"key"`
+	AccountName string    `json:"account_name,omitempty"`
+	Valid       bool      `json:"valid"`
+	Reason      string    `json:"reason,omitempty"`
+	CheckedAt   time.Time `json:"checked_at"`
+	Records     []int64   `json:"records"`
+
+	// notified 失效后已经通知过，恢复有效后重置
+	notified bool
+}
+
+// SessionValidator 定时检查所有记录使用的cookie，失效时把记录标记为错误并通知一次
+type SessionValidator struct {
+	watchers func() []Watcher
+
+	lock     sync.Mutex
+	states   map[string]*SessionState
+	stopChan chan struct{}
+	stopOnce *sync.Once
+	wg       sync.WaitGroup
+}
+
+func NewSessionValidator(watchers func() []Watcher) *SessionValidator {
+	return &SessionValidator{
+		watchers: watchers,
+		states:   map[string]*SessionState{},
+		stopChan: make(chan struct{}),
+		stopOnce: &sync.Once{},
+	}
+}
+
+func (v *SessionValidator) Run() {
+	v.wg.Add(1)
+	go func() {
+		defer v.wg.Done()
+		ticker := time.NewTicker(sessionCheckInterval())
+		defer ticker.Stop()
+		for {
+			select {
+			case <-v.stopChan:
+				return
+			case <-ticker.C:
+				v.CheckAll(context.Background())
+			}
+		}
+	}()
+}
+
+func (v *SessionValidator) Stop() {
+	v.stopOnce.Do(func() {
+		close(v.stopChan)
+	})
+	v.wg.Wait()
+}
+
+// Check 检查单个cookie，用于添加记录时校验
+func (v *SessionValidator) Check(ctx context.Context, s Session) error {
+	_, err := checkSession(ctx, s)
+	return err
+}
+
+// CheckAll 按账号分组检查，每个cookie只请求一次
+func (v *SessionValidator) CheckAll(ctx context.Context) {
+	groups := map[string][]Watcher{}
+	sessions := map[string]Session{}
+	for _, w := range v.watchers() {
+		s := w.Session()
+		if s.Key == "" || s.Cookie == "" {
+			continue
+		}
+		groups[s.Key] = append(groups[s.Key], w)
+		sessions[s.Key] = s
+	}
+
+	for key, ws := range groups {
+		s := sessions[key]
+		accountName, err := checkSession(ctx, s)
+		if err != nil && !poetrader.IsFatal(err) {
+			// 网络错误或者限频，不能说明cookie失效
+			logrus.WithContext(ctx).Warnf("check session %s fail, err: %v", key, err)
+			continue
+		}
+		v.update(ctx, key, accountName, err, ws)
+	}
+}
+
+func (v *SessionValidator) update(ctx context.Context, key string, accountName string, checkErr error, ws []Watcher) {
+	v.lock.Lock()
+	state, ok := v.states[key]
+	if !ok {
+		state = &SessionState{Key: key}
+		v.states[key] = state
+	}
+	state.CheckedAt = time.Now()
+	state.Valid = checkErr == nil
+	state.Records = state.Records[:0]
+	for _, w := range ws {
+		state.Records = append(state.Records, w.Record().ID)
+	}
+	sort.Slice(state.Records, func(i, j int) bool { return state.Records[i] < state.Records[j] })
+	if state.Valid {
+		state.AccountName = accountName
+		state.Reason = ""
+		state.notified = false
+		v.lock.Unlock()
+		return
+	}
+	state.Reason = checkErr.Error()
+	needNotify := !state.notified
+	state.notified = true
+	v.lock.Unlock()
+
+	logrus.WithContext(ctx).Errorf("session %s invalid, err: %v", key, checkErr)
+	reason := fmt.Errorf("cookie expired: %w", checkErr)
+	names := make([]string, 0, len(ws))
+	for _, w := range ws {
+		if w.Status().State.Running() {
+			w.Fail(reason)
+		}
+		names = append(names, fmt.Sprintf("%d:%s", w.Record().ID, w.Record().Name))
+	}
+	if !needNotify {
+		return
+	}
+	msg := fmt.Sprintf("账号 %s 的cookie已失效，请更新cookie\n受影响的记录: %s", key, strings.Join(names, ", "))
+	notifyClient, err := notifierForRecords(ws)
+	if err != nil {
+		logrus.WithContext(ctx).Errorf("notifierForRecords fail, err: %v", err)
+		return
+	}
+	if err := notifyClient.Send(ctx, &notify.Message{Text: msg}); err != nil {
+		logrus.WithContext(ctx).Errorf("Send fail, err: %v", err)
+	}
+}
+
+func (v *SessionValidator) States() []SessionState {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	res := make([]SessionState, 0, len(v.states))
+	for _, s := range v.states {
+		res = append(res, *s)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Key < res[j].Key })
+	return res
+}
+
+// ReplaceCookie 替换账号的cookie，并重启所有使用该账号的记录
+// 账号池中的账号只在内存中替换，重启后恢复为配置中的cookie
+func (v *SessionValidator) ReplaceCookie(ctx context.Context, key string, cookie string) ([]int64, error) {
+	ws := []Watcher{}
+	session := Session{Key: key}
+	for _, w := range v.watchers() {
+		if s := w.Session(); s.Key == key {
+			ws = append(ws, w)
+			session = s
+		}
+	}
+	_, isPoolAccount := Account(key)
+	if len(ws) == 0 && !isPoolAccount {
+		return nil, fmt.Errorf("unknown session %s", key)
+	}
+	session.Cookie = cookie
+	if _, err := checkSession(ctx, session); poetrader.IsFatal(err) {
+		return nil, err
+	}
+
+	if isPoolAccount {
+		setAccountCookie(key, cookie)
+	}
+
+	restarted := []int64{}
+	for _, w := range ws {
+		record := w.Record()
+		// 暂停的记录只更新cookie，不重启
+		state := w.Status().State
+		if state.Running() {
+			w.Stop()
+		}
+		if record.Cookie != "" {
+			w.setCookie(cookie)
+			if err := dao.NewClient().UpdateRecordCookie(ctx, record.ID, cookie); err != nil {
+				logrus.WithContext(ctx).Errorf("UpdateRecordCookie fail, err: %v", err)
+				return restarted, err
+			}
+		}
+		if state == StatePaused || state == StateStopped {
+			continue
+		}
+		if err := w.Run(); err != nil {
+			logrus.WithContext(ctx).Errorf("restart record %d fail, err: %v", record.ID, err)
+			continue
+		}
+		restarted = append(restarted, record.ID)
+	}
+
+	v.lock.Lock()
+	delete(v.states, key)
+	v.lock.Unlock()
+	return restarted, nil
+}
+
+func checkSession(ctx context.Context, s Session) (string, error) {
+	realm, err := poetrader.GetRealm(s.Realm)
+	if err != nil {
+		return "", err
+	}
+	opts := []poetrader.Option{poetrader.WithRealm(realm)}
+	if s.Proxy != "" {
+		proxyURL, err := poetrader.ParseProxy(s.Proxy)
+		if err != nil {
+			return "", err
+		}
+		opts = append(opts, poetrader.WithProxy(proxyURL))
+	}
+	c := poetrader.New("", s.Cookie, opts...)
+	defer func() {
+		_ = c.Stop(ctx)
+	}()
+	return c.CheckSession(ctx)
+}
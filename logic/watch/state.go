@@ -0,0 +1,141 @@
+package watch
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ink19/poewatcher/logic/dao"
+	"github.com/sirupsen/logrus"
+)
+
+type State string
+
+const (
+	// StateStarting 已经调用Run，正在分配账号和创建客户端
+	StateStarting State = "starting"
+	// StateConnecting 正在搜索、等待直播连接或者建立连接
+	StateConnecting State = "connecting"
+	// StateLive 直播搜索连接正常
+	StateLive State = "live"
+	// StateReconnecting 直播搜索断开，等待重连
+	StateReconnecting State = "reconnecting"
+	// StatePolling 轮询模式、auto模式的轮询阶段以及通货兑换
+	StatePolling State = "polling"
+	// StatePaused 没有运行，对应数据库中的RecordStatusPending
+	StatePaused State = "paused"
+	// StateErrored 出错退出，对应数据库中的RecordStatusError
+	StateErrored State = "errored"
+	// StateStopped 已经删除或者程序退出，不能再运行
+	StateStopped State = "stopped"
+)
+
+var (
+	// ErrStopped watcher已经删除或者关闭
+	ErrStopped = errors.New("watcher stopped")
+	// errUnexpectedExit 监听在没有停止也没有错误的情况下退出
+	errUnexpectedExit = errors.New("watch exited unexpectedly")
+)
+
+// transitions 每个状态允许转换到的状态
+var transitions = map[State][]State{
+	StatePaused:       {StateStarting, StateStopped},
+	StateErrored:      {StateStarting, StatePaused, StateErrored, StateStopped},
+	StateStarting:     {StateConnecting, StatePolling, StatePaused, StateErrored, StateStopped},
+	StateConnecting:   {StateLive, StateConnecting, StatePolling, StatePaused, StateErrored, StateStopped},
+	StateLive:         {StateReconnecting, StateConnecting, StatePolling, StatePaused, StateErrored, StateStopped},
+	StateReconnecting: {StateLive, StateReconnecting, StateConnecting, StatePolling, StatePaused, StateErrored, StateStopped},
+	StatePolling:      {StateConnecting, StatePolling, StatePaused, StateErrored, StateStopped},
+	StateStopped:      {},
+}
+
+// Running 返回true时有goroutine在监听
+func (s State) Running() bool {
+	switch s {
+	case StateStarting, StateConnecting, StateLive, StateReconnecting, StatePolling:
+		return true
+	}
+	return false
+}
+
+func (s State) canTransit(to State) bool {
+	for _, next := range transitions[s] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Status watcher的运行状态，只保存在内存中
+type Status struct {
+	State State `json:"state"`
+	// Since 进入当前状态的时间
+	Since time.Time `json:"since"`
+	// StartedAt 最后一次Run的时间
+	StartedAt *time.Time `json:"started_at,omitempty"`
+	// LastError 最近一次错误，包括重连前的错误
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+	// Hits 获取到详情的物品数，Dropped 其中被过滤表达式丢弃的数量
+	Hits    int64 `json:"hits"`
+	Dropped int64 `json:"dropped"`
+}
+
+// initialStatus 根据数据库中的状态生成，Run之前只可能是paused或者errored
+func initialStatus(r *dao.Record) Status {
+	now := time.Now()
+	if r.Status == dao.RecordStatusError {
+		return Status{State: StateErrored, Since: now, LastError: r.Reason}
+	}
+	return Status{State: StatePaused, Since: now}
+}
+
+// setStateLocked 调用前需要持有w.lock，不允许的转换会被忽略
+func (w *watcher) setStateLocked(to State, reason error) bool {
+	from := w.status.State
+	if !from.canTransit(to) {
+		logrus.Warnf("record %d ignore state transition %s -> %s", w.record.ID, from, to)
+		return false
+	}
+	now := time.Now()
+	if from != to {
+		w.status.Since = now
+		logrus.Debugf("record %d state %s -> %s", w.record.ID, from, to)
+	}
+	w.status.State = to
+	if to == StateStarting {
+		w.status.StartedAt = &now
+	}
+	if reason != nil {
+		w.status.LastError = reason.Error()
+		w.status.LastErrorAt = &now
+	}
+	return true
+}
+
+// transit 监听goroutine中的状态转换，ctx已经取消说明Stop、Delete或者Fail已经设置了状态，直接忽略
+func (w *watcher) transit(ctx context.Context, to State, reason error) bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if ctx.Err() != nil {
+		return false
+	}
+	return w.setStateLocked(to, reason)
+}
+
+// transitFrom 只在当前状态为from时转换，避免覆盖重连回调已经设置的状态
+func (w *watcher) transitFrom(ctx context.Context, from State, to State, reason error) bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if ctx.Err() != nil || w.status.State != from {
+		return false
+	}
+	return w.setStateLocked(to, reason)
+}
+
+func (w *watcher) Status() Status {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.status
+}
@@ -0,0 +1,219 @@
+package watch
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/ink19/poewatcher/config"
+	"github.com/ink19/poewatcher/logic/dao"
+	"github.com/ink19/poewatcher/logic/poetrader"
+	"github.com/ink19/poewatcher/logic/pricing"
+	"github.com/ink19/poewatcher/pkg/itemtext"
+	"github.com/ink19/poewatcher/pkg/notify"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultTextTemplate = "{{.Desc}}{{with .Value}}\n--------\n估值: {{.Amount}} {{.Currency}} ≈ {{.}}{{end}}"
+
+	defaultMarkdownTemplate = `**{{.Record}}** {{with .Item}}{{if and .Name (ne .Name .Base)}}{{.Name}} {{end}}{{.Base}}{{end}}
+{{with .Price}}> 价格: <font color="warning">{{.Amount}} {{.Currency}}</font>{{end}}{{with .Value}} ≈ {{.}}{{end}}
+{{with .Seller}}> 卖家: {{.LastCharacterName}}{{end}}
+{{range .Item.Mods}}> {{.Text}}
+{{end}}{{with .Whisper}}` + "`{{.}}`" + `
+{{end}}{{with .TradeURL}}[交易链接]({{.}}){{end}}`
+)
+
+// NotifyData 通知模板可以使用的字段
+type NotifyData struct {
+	// Record 记录名称
+	Record string
+	ItemID string
+	Item   *itemtext.Item
+	// Desc 交易接口返回的物品文本，和游戏内复制的格式相同
+	Desc  string
+	Price *poetrader.PoePrice
+	// Value 折算后的价格，没有配置价格来源或者无法折算时为nil
+	Value    *pricing.Value
+	Seller   *poetrader.PoeAccount
+	Whisper  string
+	TradeURL string
+}
+
+type templates struct {
+	text     *template.Template
+	markdown *template.Template
+}
+
+var defaultTemplates = &templates{
+	text:     template.Must(template.New("text").Parse(defaultTextTemplate)),
+	markdown: template.Must(template.New("markdown").Parse(defaultMarkdownTemplate)),
+}
+
+// compileTemplates 记录中的模板优先，其次是全局配置，都没有时使用默认模板
+func compileTemplates(t *dao.NotifyTemplate) (*templates, error) {
+	cfg := config.Get().Notify
+	textSrc, markdownSrc := cfg.Template, cfg.MarkdownTemplate
+	if t != nil && t.Text != "" {
+		textSrc = t.Text
+	}
+	if t != nil && t.Markdown != "" {
+		markdownSrc = t.Markdown
+	}
+	res := &templates{text: defaultTemplates.text, markdown: defaultTemplates.markdown}
+	if strings.TrimSpace(textSrc) != "" {
+		tmpl, err := template.New("text").Parse(textSrc)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template: %w", err)
+		}
+		res.text = tmpl
+	}
+	if strings.TrimSpace(markdownSrc) != "" {
+		tmpl, err := template.New("markdown").Parse(markdownSrc)
+		if err != nil {
+			return nil, fmt.Errorf("invalid markdown template: %w", err)
+		}
+		res.markdown = tmpl
+	}
+	// 字段名写错时执行才会报错，用示例物品检查一次
+	if _, err := res.render(sampleData()); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// ValidateTemplate 添加记录时检查模板的语法和字段名
+func ValidateTemplate(t *dao.NotifyTemplate) error {
+	_, err := compileTemplates(t)
+	return err
+}
+
+func (t *templates) render(data *NotifyData) (*notify.Message, error) {
+	text := &bytes.Buffer{}
+	if err := t.text.Execute(text, data); err != nil {
+		return nil, fmt.Errorf("render template: %w", err)
+	}
+	markdown := &bytes.Buffer{}
+	if err := t.markdown.Execute(markdown, data); err != nil {
+		return nil, fmt.Errorf("render markdown template: %w", err)
+	}
+	return &notify.Message{Text: text.String(), Markdown: markdown.String()}, nil
+}
+
+// notifyData 生成模板数据，物品文本无法解码时返回错误
+func notifyData(record *dao.Record, good *poetrader.PoeGood, value *pricing.Value) (*NotifyData, error) {
+	desc, err := base64.StdEncoding.DecodeString(good.Item.Extended.DescText)
+	if err != nil {
+		return nil, err
+	}
+	data := &NotifyData{
+		Record:  record.Name,
+		ItemID:  good.ID,
+		Item:    describeItem(&good.Item),
+		Desc:    string(desc),
+		Price:   good.Listing.Price,
+		Value:   value,
+		Seller:  good.Listing.Account,
+		Whisper: good.Listing.Whisper,
+	}
+	if record.SearchID != "" {
+		if realm, err := poetrader.GetRealm(record.Realm); err == nil {
+			data.TradeURL = realm.TradeURL(record.SeasonID, record.SearchID)
+		}
+	}
+	return data, nil
+}
+
+const sampleDesc = `Item Class: Rings
+Rarity: Rare
+Storm Loop
+Two-Stone Ring
+--------
+Requirements:
+Level: 48
+--------
+Item Level: 84
+--------
++12% to Cold and Lightning Resistances (implicit)
+--------
++68 to maximum Life
++42% to Fire Resistance
++37% to Cold Resistance
++11% to Chaos Resistance`
+
+func sampleGood() *poetrader.PoeGood {
+	return &poetrader.PoeGood{
+		ID: "sample",
+		Listing: poetrader.PoeListing{
+			Indexed: time.Now(),
+			Whisper: `@StormCaller Hi, I would like to buy your Storm Loop Two-Stone Ring listed for 1 divine in Standard`,
+			Account: &poetrader.PoeAccount{Name: "sample#0000", LastCharacterName: "StormCaller", Online: &poetrader.PoeOnline{League: "Standard"}},
+			Price:   &poetrader.PoePrice{Type: "~price", Amount: 1, Currency: "divine"},
+		},
+		Item: poetrader.PoeItem{
+			Extended: poetrader.PoeItemExtended{DescText: base64.StdEncoding.EncodeToString([]byte(sampleDesc))},
+		},
+	}
+}
+
+func sampleData() *NotifyData {
+	record := &dao.Record{Name: "sample", SeasonID: "Standard", SearchID: "sample", Realm: poetrader.RealmPC}
+	data, _ := notifyData(record, sampleGood(), &pricing.Value{Amount: 1, Currency: "divine", Chaos: 200, Divine: 1})
+	return data
+}
+
+// Preview 用模板渲染记录最近一次通知的物品，没有通知过或者sample为true时使用示例物品
+// t为nil时使用记录的模板，usedSample表示是否使用了示例物品
+func Preview(ctx context.Context, record *dao.Record, t *dao.NotifyTemplate, sample bool) (msg *notify.Message, usedSample bool, err error) {
+	if t == nil {
+		t = record.Template
+	}
+	tmpls, err := compileTemplates(t)
+	if err != nil {
+		return nil, false, err
+	}
+	if !sample && record.ID != 0 {
+		hit, err := dao.NewClient().GetLastHit(ctx, record.ID)
+		if err != nil {
+			return nil, false, err
+		}
+		if hit != nil {
+			good := &poetrader.PoeGood{}
+			if err := json.Unmarshal(hit.Data, good); err != nil {
+				return nil, false, err
+			}
+			w := &watcher{record: record}
+			data, err := notifyData(record, good, w.goodValue(ctx, good))
+			if err != nil {
+				return nil, false, err
+			}
+			msg, err := tmpls.render(data)
+			return msg, false, err
+		}
+	}
+	data := sampleData()
+	if record.Name != "" {
+		data.Record = record.Name
+	}
+	msg, err = tmpls.render(data)
+	return msg, true, err
+}
+
+// saveLastHit 保存最近一次通知的物品，失败时只记录日志
+func (w *watcher) saveLastHit(ctx context.Context, good *poetrader.PoeGood) {
+	raw, err := json.Marshal(good)
+	if err != nil {
+		logrus.WithContext(ctx).Errorf("Marshal good %s fail, err: %v", good.ID, err)
+		return
+	}
+	hit := &dao.Hit{RecordID: w.record.ID, Data: raw, CreatedAt: time.Now()}
+	if err := dao.NewClient().SaveLastHit(ctx, hit); err != nil {
+		logrus.WithContext(ctx).Errorf("SaveLastHit fail, err: %v", err)
+	}
+}
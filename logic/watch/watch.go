@@ -2,21 +2,63 @@ package watch
 
 import (
 	"context"
-	"encoding/base64"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/ink19/poewatcher/config"
 	"github.com/ink19/poewatcher/logic/dao"
 	"github.com/ink19/poewatcher/logic/poetrader"
+	"github.com/ink19/poewatcher/logic/pricing"
+	"github.com/ink19/poewatcher/pkg/filter"
 	"github.com/ink19/poewatcher/pkg/notify"
 	"github.com/sirupsen/logrus"
 )
 
+const (
+	fetchRetryDelay = 5 * time.Second
+	defaultSeenTTL  = 24 * time.Hour
+	// seenPruneInterval 删除过期标记的间隔
+	seenPruneInterval = time.Hour
+)
+
+func seenTTL() time.Duration {
+	if v := config.Get().Poe.SeenTTL; v > 0 {
+		return time.Duration(v) * time.Hour
+	}
+	return defaultSeenTTL
+}
+
 type Watcher interface {
+	// Run 开始监听，已经在运行时直接返回
 	Run() error
+	// Stop 停止监听并把记录标记为暂停，没有运行时只更新状态
 	Stop()
+	// Delete 停止监听并删除记录，之后不能再运行
 	Delete()
+	// Close 停止监听但不修改数据库中的状态，用于程序退出
+	Close()
+	// Fail 停止监听并把记录标记为错误
+	Fail(reason error)
 	Record() *dao.Record
+	// Status 返回运行状态
+	Status() Status
+	// Session 返回记录使用的账号
+	Session() Session
+
+	// setCookie 替换记录的cookie，下次Run时生效
+	setCookie(cookie string)
+}
+
+// Session Key为账号池中的账号名，直接配置cookie时为cookie的摘要
+type Session struct {
+	Key    string
+	Cookie string
+	Realm  string
+	Proxy  string
 }
 
 type watcher struct {
@@ -24,7 +66,26 @@ type watcher struct {
 	ctx context.Context
 	c poetrader.Client
 	done context.CancelFunc
+	// account 从账号池分配的账号
+	account string
+	// sessionKey 用于直播搜索连接数调度
+	sessionKey string
+	// recorder 配置了RecordDir时录制交易请求
+	recorder *poetrader.Recorder
+	// pollSeen 轮询时见过的ID和最后一次出现的时间
+	pollSeen map[string]time.Time
+	// prunedAt 最后一次删除过期标记的时间，只在监听的goroutine中读写
+	prunedAt time.Time
+	status Status
+	// filter 记录的过滤表达式，Run时解析
+	filter *filter.Expr
+	// templates 通知消息模板，Run时解析
+	templates *templates
+	// digest 记录设置了汇总时不为nil，只在监听的goroutine中读写
+	digest *digest
 
+	// opLock 保证Run、Stop、Delete、Close和Fail依次执行，lock保护字段
+	opLock sync.Mutex
 	lock sync.Locker
 	wg sync.WaitGroup
 }
@@ -32,111 +93,677 @@ type watcher struct {
 func New(r *dao.Record) Watcher {
 	return &watcher{
 		record: r,
+		status: initialStatus(r),
 		lock: &sync.Mutex{},
 	}
 }
 
 func (w *watcher) Run() error {
+	w.opLock.Lock()
+	defer w.opLock.Unlock()
+
 	w.lock.Lock()
-	defer w.lock.Unlock()
+	state := w.status.State
+	w.lock.Unlock()
+	if state == StateStopped {
+		return ErrStopped
+	}
+	if state.Running() {
+		logrus.Debugf("record %d is %s, skip Run", w.record.ID, state)
+		return nil
+	}
 
-	ctx, done := context.WithCancel(context.Background())
-	w.ctx = ctx
-	w.done = done
+	expr, err := compileFilter(w.record.Filter)
+	if err != nil {
+		logrus.Errorf("record %d compileFilter fail, err: %v", w.record.ID, err)
+		return err
+	}
+	w.filter = expr
+	if w.record.Kind != dao.RecordKindExchange {
+		tmpls, err := compileTemplates(w.record.Template)
+		if err != nil {
+			logrus.Errorf("record %d compileTemplates fail, err: %v", w.record.ID, err)
+			return err
+		}
+		w.templates = tmpls
+	}
 
+	ctx, done := context.WithCancel(context.Background())
 	logrus.WithContext(ctx).Debugf("Begin Run, record: %v", w.record)
 
 	if err := w.initRecord(ctx); err != nil {
 		logrus.WithContext(ctx).Errorf("initRecord fail, err: %v", err)
+		done()
 		return err
 	}
 
+	w.lock.Lock()
+	w.ctx = ctx
+	w.done = done
+	w.setStateLocked(StateStarting, nil)
+	w.lock.Unlock()
+
 	w.wg.Add(1)
 	go func ()  {
 		defer w.wg.Done()
-		_ = w.WatchRecord(ctx)
+		var err error
+		if w.record.Kind == dao.RecordKindExchange {
+			err = w.WatchExchange(ctx)
+		} else {
+			err = w.WatchRecord(ctx)
+		}
+		if err == nil && ctx.Err() == nil {
+			err = errUnexpectedExit
+		}
+		if err != nil {
+			logrus.WithContext(ctx).Errorf("Watch record %d exit, err: %v", w.record.ID, err)
+			w.setError(ctx, err)
+		}
 	}()
 	return nil
 }
 
+// Record 返回记录的副本，监听goroutine会修改SearchID等字段
 func (w *watcher) Record() *dao.Record {
-	return w.record
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	record := *w.record
+	return &record
 }
 
-func (w *watcher) Stop() {
+func (w *watcher) setCookie(cookie string) {
+	w.updateRecord(func(r *dao.Record) {
+		r.Cookie = cookie
+	})
+}
+
+// updateRecord 在w.lock中修改记录，其他goroutine通过Record读取
+func (w *watcher) updateRecord(update func(r *dao.Record)) {
 	w.lock.Lock()
 	defer w.lock.Unlock()
-	
-	_ = w.c.Stop(w.ctx)
-	w.record.Status = dao.RecordStatusPending
-	err := dao.NewClient().UpdateRecordStatus(w.ctx, w.record.ID, dao.RecordStatusPending)
+	update(w.record)
+}
+
+// halt 取消正在运行的监听并转换到to，返回false表示已经停止，不需要再处理
+func (w *watcher) halt(to State, reason error) bool {
+	w.lock.Lock()
+	if w.status.State == StateStopped {
+		w.lock.Unlock()
+		return false
+	}
+	running := w.status.State.Running()
+	if running {
+		w.done()
+	}
+	if !w.setStateLocked(to, reason) {
+		w.lock.Unlock()
+		return false
+	}
+	c := w.c
+	w.lock.Unlock()
+
+	if running && c != nil {
+		_ = c.Stop(context.Background())
+	}
+	w.wg.Wait()
+	return true
+}
+
+func (w *watcher) Stop() {
+	w.opLock.Lock()
+	defer w.opLock.Unlock()
+
+	if !w.halt(StatePaused, nil) || w.record.ID == 0 {
+		return
+	}
+	w.updateRecord(func(r *dao.Record) {
+		r.Status = dao.RecordStatusPending
+		r.Reason = ""
+	})
+	err := dao.NewClient().UpdateRecordStatus(context.Background(), w.record.ID, dao.RecordStatusPending)
 	if err != nil {
-		logrus.WithContext(w.ctx).Errorf("update record status fail, err: %v", err)
+		logrus.Errorf("update record status fail, err: %v", err)
 	}
 }
 
-func (w *watcher) Delete() {
+func (w *watcher) Fail(reason error) {
+	w.opLock.Lock()
+	defer w.opLock.Unlock()
+
+	if !w.halt(StateErrored, reason) {
+		return
+	}
+	w.saveError(context.Background(), reason)
+}
+
+func (w *watcher) Close() {
+	w.opLock.Lock()
+	defer w.opLock.Unlock()
+
+	w.halt(StateStopped, nil)
+}
+
+func (w *watcher) Session() Session {
 	w.lock.Lock()
 	defer w.lock.Unlock()
 
-	_ = w.c.Stop(w.ctx)
-	if err := dao.NewClient().DeleteRecord(w.ctx, w.record.ID); err != nil {
-		logrus.WithContext(w.ctx).Errorf("delete record fail, err: %v", err)
+	s := Session{Realm: w.record.Realm}
+	switch {
+	case w.record.Cookie != "":
+		s.Key = poetrader.AccountKey(w.record.Cookie)
+		s.Cookie = w.record.Cookie
+	case w.account != "":
+		s.Key = w.account
+	case w.record.Account != "":
+		s.Key = w.record.Account
+	}
+	if s.Cookie == "" && s.Key != "" {
+		account, _ := Account(s.Key)
+		s.Cookie = account.Cookie
+	}
+	s.Proxy = resolveProxy(w.record, s.Key)
+	return s
+}
+
+func (w *watcher) Delete() {
+	w.opLock.Lock()
+	defer w.opLock.Unlock()
+
+	if !w.halt(StateStopped, nil) || w.record.ID == 0 {
+		return
+	}
+	if err := dao.NewClient().DeleteRecord(context.Background(), w.record.ID); err != nil {
+		logrus.Errorf("delete record fail, err: %v", err)
 	}
 }
 
 func (w *watcher) WatchRecord(ctx context.Context) error {
-	poeClient := poetrader.New(w.record.SeasonID, w.record.Cookie)
-	w.c = poeClient
-	
-	ch, err := poeClient.Watch(ctx, w.record.SearchID)
+	poeClient, err := w.newClient(poetrader.WithReconnectHandler(w.onReconnect), poetrader.WithReconnectedHandler(w.onReconnected))
+	if err != nil {
+		logrus.WithContext(ctx).Errorf("newClient fail, err: %v", err)
+		return err
+	}
+	defer w.releaseClient()
+
+	// 每次启动时先删除一次
+	w.prunedAt = time.Time{}
+	w.pruneSeen(ctx)
+
+	notifyClient, err := newNotifier(w.record.Notifiers)
+	if err != nil {
+		return err
+	}
+	// 退出时发送还没有到时间的汇总
+	w.digest = newDigest(w, notifyClient, w.record.Digest)
+	defer w.digest.close()
+	if w.record.Mode == dao.RecordModePoll {
+		return w.pollSearch(ctx, poeClient, notifyClient)
+	}
+
+	for {
+		again, err := w.watchLive(ctx, poeClient, notifyClient)
+		liveErr := &liveError{}
+		if errors.As(err, &liveErr) && w.canPoll() && !errors.Is(err, poetrader.ErrUnauthorized) {
+			logrus.WithContext(ctx).Warnf("record %d live search unavailable, poll for %s, err: %v", w.record.ID, liveRetryInterval(), err)
+			pollCtx, cancel := context.WithTimeout(ctx, liveRetryInterval())
+			err = w.pollSearch(pollCtx, poeClient, notifyClient)
+			cancel()
+			again = err == nil && ctx.Err() == nil
+		}
+		if err != nil {
+			return err
+		}
+		if !again {
+			return nil
+		}
+	}
+}
+
+// liveError 直播连接失败，auto模式下会改为轮询
+type liveError struct {
+	err error
+}
+
+func (e *liveError) Error() string {
+	return e.err.Error()
+}
+
+func (e *liveError) Unwrap() error {
+	return e.err
+}
+
+// canPoll auto模式并且有查询时，直播搜索不可用可以改为轮询
+func (w *watcher) canPoll() bool {
+	return w.record.Mode == dao.RecordModeAuto && len(w.record.Query) > 0
+}
+
+// watchLive 分配到直播连接后监听，again为true时需要重新分配连接
+func (w *watcher) watchLive(ctx context.Context, poeClient poetrader.Client, notifyClient notify.Notifier) (again bool, err error) {
+	w.transit(ctx, StateConnecting, nil)
+	acquireCtx, cancelAcquire := context.WithCancel(ctx)
+	defer cancelAcquire()
+
+	// 连接数不足时先轮询，轮询失败则不再等待
+	var (
+		waiting func(ctx context.Context)
+		pollErr error
+	)
+	if w.canPoll() {
+		waiting = func(waitCtx context.Context) {
+			logrus.WithContext(waitCtx).Infof("record %d wait for live slot, poll meanwhile", w.record.ID)
+			if err := w.pollSearch(waitCtx, poeClient, notifyClient); err != nil {
+				pollErr = err
+				cancelAcquire()
+			}
+		}
+	}
+	slotCtx, release, err := scheduler.acquire(acquireCtx, w.sessionKey, w.record, waiting)
+	if pollErr != nil {
+		if err == nil {
+			release()
+		}
+		return false, pollErr
+	}
+	if err != nil {
+		logrus.WithContext(ctx).Debugf("record %d stop waiting for live slot", w.record.ID)
+		return false, nil
+	}
+	w.transit(ctx, StateConnecting, nil)
+	ch, err := w.watchSearch(slotCtx, poeClient)
 	if err != nil {
+		release()
 		logrus.WithContext(ctx).Errorf("Watch search fail, err: %v", err)
-		return nil
+		return false, &liveError{err: err}
+	}
+	// 第一次连接失败时在后台重连，连接成功后由onReconnected设置为live
+	w.transitFrom(ctx, StateConnecting, StateLive, nil)
+
+	fetchErr := w.consumeGoods(poeClient, ch, notifyClient, func() {
+		_ = poeClient.Stop(context.Background())
+	})
+	// 连接被调度收回时，ctx未取消但slotCtx已取消
+	revoked := ctx.Err() == nil && slotCtx.Err() != nil
+	release()
+	if fetchErr != nil {
+		return false, fetchErr
 	}
 
+	err = poeClient.Err()
+	if err == nil && revoked {
+		logrus.WithContext(ctx).Infof("record %d live slot revoked, wait for next slot", w.record.ID)
+		return true, nil
+	}
+	if errors.Is(err, poetrader.ErrNotFound) && len(w.record.Query) > 0 {
+		logrus.WithContext(ctx).Infof("record %d search %s expired, search again", w.record.ID, w.record.SearchID)
+		w.updateRecord(func(r *dao.Record) {
+			r.SearchID = ""
+		})
+		return true, nil
+	}
+	if err != nil {
+		return false, &liveError{err: err}
+	}
+	return false, nil
+}
+
+// consumeGoods 按批获取详情并通知，直到ch关闭
+// cookie失效或者被封禁时调用stop结束ch，并返回错误
+func (w *watcher) consumeGoods(c poetrader.Client, ch <-chan *poetrader.PoeGood, notifyClient notify.Notifier, stop func()) error {
 	// 使用新的ctx，不影响原来的ctx
-	ctx = context.Background()
-	notifyClient := notify.NewWxWork(config.Get().Notify.URL)
-	for good := range ch {
-		logrus.WithContext(ctx).Debugf("goodID: %s", good.ID)
-		good, err := poeClient.GetInfo(ctx, w.record.SearchID, good.ID)
-		if err != nil {
-			logrus.WithContext(ctx).Errorf("GetInfo fail, err: %v", err)
+	fetchCtx := context.Background()
+	var fetchErr error
+	batches := batchGoods(ch, batchWindow(), poetrader.MaxFetchIDs)
+	for ids := range batches {
+		if fetchErr != nil {
 			continue
 		}
-		logrus.WithContext(ctx).Debugf("GetInfo succ, good: %v", good)
-		desc, err := base64.StdEncoding.DecodeString(good.Item.Extended.DescText)
-		if err != nil {
-			logrus.WithContext(ctx).Errorf("GetDesc fail, err: %v", err)
+		ids = w.filterSeen(fetchCtx, ids)
+		if len(ids) == 0 {
+			continue
+		}
+		logrus.WithContext(fetchCtx).Debugf("goodIDs: %v", ids)
+		goods, err := w.fetchGoods(fetchCtx, c, ids)
+		if errors.Is(err, poetrader.ErrUnauthorized) || errors.Is(err, poetrader.ErrForbidden) {
+			logrus.WithContext(fetchCtx).Errorf("BatchGetInfo fail, stop watch, err: %v", err)
+			fetchErr = err
+			if stop != nil {
+				stop()
+			}
 			continue
 		}
-		logrus.WithContext(ctx).Debugf("%s", desc)
-		err = notifyClient.SendTextMsg(ctx, string(desc))
 		if err != nil {
-			logrus.WithContext(ctx).Errorf("SendTextMsg fail, err: %v", err)
+			logrus.WithContext(fetchCtx).Errorf("BatchGetInfo fail, err: %v", err)
+			continue
+		}
+		values := w.sortByValue(fetchCtx, goods)
+		kept := w.applyFilter(fetchCtx, goods, values)
+		// 被过滤的物品同样标记，之后不再获取详情；发送失败的不标记，再次出现时重新通知
+		seen := make([]string, 0, len(goods))
+		keptIDs := map[string]struct{}{}
+		for _, good := range kept {
+			keptIDs[good.ID] = struct{}{}
+		}
+		for _, good := range goods {
+			if _, ok := keptIDs[good.ID]; !ok {
+				seen = append(seen, good.ID)
+			}
+		}
+		for _, good := range kept {
+			// 汇总发送成功后再标记
+			if w.digest != nil {
+				w.digest.add(good, values[good.ID])
+				continue
+			}
+			if w.notifyGood(fetchCtx, notifyClient, w.record, good, values[good.ID]) {
+				seen = append(seen, good.ID)
+			}
+		}
+		w.markSeen(fetchCtx, seen)
+		w.pruneSeen(fetchCtx)
+	}
+	return fetchErr
+}
+
+// markSeen 标记物品已经处理过，seenTTL内不再通知
+func (w *watcher) markSeen(ctx context.Context, ids []string) {
+	if err := dao.NewClient().AddSeenItems(ctx, w.record.ID, ids, time.Now()); err != nil {
+		logrus.WithContext(ctx).Errorf("AddSeenItems fail, err: %v", err)
+	}
+}
+
+// pruneSeen 定期删除过期的标记，长时间运行的记录不会无限增长
+func (w *watcher) pruneSeen(ctx context.Context) {
+	if time.Since(w.prunedAt) < seenPruneInterval {
+		return
+	}
+	w.prunedAt = time.Now()
+	if err := dao.NewClient().DeleteSeenItems(ctx, w.record.ID, time.Now().Add(-seenTTL())); err != nil {
+		logrus.WithContext(ctx).Errorf("DeleteSeenItems fail, err: %v", err)
+	}
+}
+
+// filterSeen 去掉重复的和已经通知过的ID，重连或者重启后同一物品不会重复通知
+// 查询失败时只去重，宁可重复通知也不漏掉
+func (w *watcher) filterSeen(ctx context.Context, ids []string) []string {
+	uniq := make([]string, 0, len(ids))
+	exists := map[string]struct{}{}
+	for _, id := range ids {
+		if _, ok := exists[id]; ok {
+			continue
 		}
+		exists[id] = struct{}{}
+		uniq = append(uniq, id)
+	}
+	res, err := dao.NewClient().FilterSeenItems(ctx, w.record.ID, uniq, time.Now().Add(-seenTTL()))
+	if err != nil {
+		logrus.WithContext(ctx).Errorf("FilterSeenItems fail, err: %v", err)
+		return uniq
+	}
+	if len(res) < len(uniq) {
+		logrus.WithContext(ctx).Debugf("record %d skip %d notified goods", w.record.ID, len(uniq)-len(res))
+	}
+	return res
+}
+
+// fetchGoods 遇到限频或者服务端错误时等待后重试一次
+func (w *watcher) fetchGoods(ctx context.Context, c poetrader.Client, ids []string) ([]*poetrader.PoeGood, error) {
+	goods, err := c.BatchGetInfo(ctx, w.record.SearchID, ids)
+	if err == nil || !poetrader.IsTemporary(err) {
+		return goods, err
+	}
+	delay := poetrader.RetryAfter(err)
+	if delay == 0 {
+		delay = fetchRetryDelay
+	}
+	logrus.WithContext(ctx).Warnf("BatchGetInfo fail, retry after %s, err: %v", delay, err)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-w.ctx.Done():
+		return nil, err
+	}
+	return c.BatchGetInfo(ctx, w.record.SearchID, ids)
+}
+
+// watchSearch 开始监听，记录带有查询时，搜索不存在或已过期会重新搜索一次
+func (w *watcher) watchSearch(ctx context.Context, c poetrader.Client) (<-chan *poetrader.PoeGood, error) {
+	if w.record.SearchID == "" {
+		if err := w.refreshSearchID(ctx, c); err != nil {
+			return nil, err
+		}
+	}
+	ch, err := c.Watch(ctx, w.record.SearchID)
+	if errors.Is(err, poetrader.ErrNotFound) && len(w.record.Query) > 0 {
+		if err := w.refreshSearchID(ctx, c); err != nil {
+			return nil, err
+		}
+		ch, err = c.Watch(ctx, w.record.SearchID)
+	}
+	return ch, err
+}
+
+func (w *watcher) refreshSearchID(ctx context.Context, c poetrader.Client) error {
+	if len(w.record.Query) == 0 {
+		return fmt.Errorf("record %d has neither search id nor query", w.record.ID)
+	}
+	res, err := c.Search(ctx, w.record.Query)
+	if err != nil {
+		logrus.WithContext(ctx).Errorf("Search fail, err: %v", err)
+		return err
+	}
+	logrus.WithContext(ctx).Infof("record %d got search id %s, total: %d", w.record.ID, res.ID, res.Total)
+	w.updateRecord(func(r *dao.Record) {
+		r.SearchID = res.ID
+	})
+	if err := dao.NewClient().UpdateRecordSearchID(ctx, w.record.ID, res.ID); err != nil {
+		logrus.WithContext(ctx).Errorf("UpdateRecordSearchID fail, err: %v", err)
+		return err
 	}
 	return nil
 }
 
+func (w *watcher) newClient(opts ...poetrader.Option) (poetrader.Client, error) {
+	realm, err := poetrader.GetRealm(w.record.Realm)
+	if err != nil {
+		return nil, err
+	}
+	account, cookie, err := pool.acquire(w.record)
+	if err != nil {
+		return nil, err
+	}
+	sessionKey := account
+	if account == "" {
+		sessionKey = poetrader.AccountKey(cookie)
+	}
+	opts = append(opts, poetrader.WithRealm(realm))
+	if proxy := resolveProxy(w.record, account); proxy != "" {
+		proxyURL, err := poetrader.ParseProxy(proxy)
+		if err != nil {
+			pool.release(account)
+			return nil, err
+		}
+		opts = append(opts, poetrader.WithProxy(proxyURL))
+	}
+	if account != "" {
+		opts = append(opts, poetrader.WithAccount(account))
+	}
+	var recorder *poetrader.Recorder
+	if dir := config.Get().Poe.RecordDir; dir != "" {
+		path := filepath.Join(dir, fmt.Sprintf("record-%d-%s.jsonl", w.record.ID, time.Now().Format("20060102150405")))
+		recorder, err = poetrader.NewRecorder(path)
+		if err != nil {
+			pool.release(account)
+			return nil, err
+		}
+		opts = append(opts, poetrader.WithRecorder(recorder))
+	}
+	poeClient := poetrader.New(w.record.SeasonID, cookie, opts...)
+	// Stop、Delete和Session在其他goroutine中读取
+	w.lock.Lock()
+	w.account = account
+	w.sessionKey = sessionKey
+	w.c = poeClient
+	w.recorder = recorder
+	w.lock.Unlock()
+	return poeClient, nil
+}
+
+// releaseClient 归还账号并关闭录制文件
+func (w *watcher) releaseClient() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	pool.release(w.account)
+	w.account = ""
+	if w.recorder != nil {
+		if err := w.recorder.Close(); err != nil {
+			logrus.Errorf("close recorder of record %d fail, err: %v", w.record.ID, err)
+		}
+		w.recorder = nil
+	}
+}
+
+// goodValue 折算物品的标价，没有标价或者无法折算时返回nil
+func (w *watcher) goodValue(ctx context.Context, good *poetrader.PoeGood) *pricing.Value {
+	price := good.Listing.Price
+	if price == nil || price.Amount <= 0 {
+		return nil
+	}
+	v, err := pricing.Normalize(ctx, w.record.SeasonID, price.Amount, price.Currency)
+	if err != nil {
+		if !errors.Is(err, pricing.ErrNoProvider) {
+			logrus.WithContext(ctx).Warnf("Normalize price of %s fail, err: %v", good.ID, err)
+		}
+		return nil
+	}
+	return v
+}
+
+// sortByValue 按折算后的价格从低到高排序，无法折算的排在最后
+func (w *watcher) sortByValue(ctx context.Context, goods []*poetrader.PoeGood) map[string]*pricing.Value {
+	values := map[string]*pricing.Value{}
+	for _, good := range goods {
+		if v := w.goodValue(ctx, good); v != nil {
+			values[good.ID] = v
+		}
+	}
+	sort.SliceStable(goods, func(i, j int) bool {
+		vi, vj := values[goods[i].ID], values[goods[j].ID]
+		if vi == nil || vj == nil {
+			return vi != nil
+		}
+		return vi.Chaos < vj.Chaos
+	})
+	return values
+}
+
+// notifyGood 返回是否发送成功
+func (w *watcher) notifyGood(ctx context.Context, notifyClient notify.Notifier, record *dao.Record, good *poetrader.PoeGood, value *pricing.Value) bool {
+	logrus.WithContext(ctx).Debugf("GetInfo succ, good: %v", good)
+	msg, err := w.renderGood(ctx, record, good, value)
+	if err != nil {
+		return false
+	}
+	logrus.WithContext(ctx).Debugf("%s", msg.Text)
+	w.saveLastHit(ctx, good)
+	err = notifyClient.Send(ctx, msg)
+	if err != nil {
+		logrus.WithContext(ctx).Errorf("Send fail, err: %v", err)
+		return false
+	}
+	return true
+}
+
+// renderGood 用记录的模板渲染物品，模板执行出错时使用默认模板，不漏掉通知
+// 不在监控协程中调用时record需要传入Record返回的副本
+func (w *watcher) renderGood(ctx context.Context, record *dao.Record, good *poetrader.PoeGood, value *pricing.Value) (*notify.Message, error) {
+	data, err := notifyData(record, good, value)
+	if err != nil {
+		logrus.WithContext(ctx).Errorf("GetDesc fail, err: %v", err)
+		return nil, err
+	}
+	tmpls := w.templates
+	if tmpls == nil {
+		tmpls = defaultTemplates
+	}
+	msg, err := tmpls.render(data)
+	if err == nil {
+		return msg, nil
+	}
+	logrus.WithContext(ctx).Errorf("record %d render fail, use default template, err: %v", w.record.ID, err)
+	msg, err = defaultTemplates.render(data)
+	if err != nil {
+		logrus.WithContext(ctx).Errorf("render default template fail, err: %v", err)
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (w *watcher) onReconnect(ctx context.Context, attempt int, delay time.Duration, err error) {
+	w.transit(w.runCtx(), StateReconnecting, err)
+	if errors.Is(err, poetrader.ErrProxy) {
+		logrus.WithContext(ctx).Errorf("record %d proxy unavailable, reconnect attempt %d in %s, err: %v", w.record.ID, attempt, delay, err)
+		return
+	}
+	if errors.Is(err, poetrader.ErrStaleConnection) {
+		logrus.WithContext(ctx).Warnf("record %d live connection stale, reconnect attempt %d in %s, err: %v", w.record.ID, attempt, delay, err)
+		return
+	}
+	logrus.WithContext(ctx).Warnf("record %d reconnect attempt %d in %s, err: %v", w.record.ID, attempt, delay, err)
+}
+
+func (w *watcher) onReconnected(ctx context.Context, attempt int) {
+	logrus.WithContext(ctx).Infof("record %d reconnected after %d attempts", w.record.ID, attempt)
+	w.transit(w.runCtx(), StateLive, nil)
+}
+
+// runCtx 返回当前运行的ctx，直播搜索的回调中使用的是分配连接时派生的ctx
+func (w *watcher) runCtx() context.Context {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.ctx
+}
+
+// setError 监听出错退出时调用，Stop、Delete或者Fail之后不再修改状态
+func (w *watcher) setError(ctx context.Context, reason error) {
+	if !w.transit(ctx, StateErrored, reason) {
+		return
+	}
+	w.saveError(ctx, reason)
+}
+
+func (w *watcher) saveError(ctx context.Context, reason error) {
+	w.updateRecord(func(r *dao.Record) {
+		r.Status = dao.RecordStatusError
+		r.Reason = reason.Error()
+	})
+	if err := dao.NewClient().SetRecordError(ctx, w.record.ID, reason.Error()); err != nil {
+		logrus.WithContext(ctx).Errorf("SetRecordError fail, err: %v", err)
+	}
+}
+
 func (w *watcher) initRecord(ctx context.Context) error {
 	if w.record.ID == 0 {
 		logrus.WithContext(ctx).Debugf("w.record.ID is 0, add to sql")
-		w.record.Status = dao.RecordStatusRunning
-		err := dao.NewClient().AddRecord(ctx, w.record)
+		record := w.Record()
+		record.Status = dao.RecordStatusRunning
+		err := dao.NewClient().AddRecord(ctx, record)
 		if err != nil {
 			logrus.WithContext(ctx).Errorf("AddRecord fail, err: %v", err)
 			return err
 		}
+		w.updateRecord(func(r *dao.Record) {
+			r.ID = record.ID
+			r.Status = record.Status
+		})
 	} else {
-		if w.record.Status == dao.RecordStatusRunning {
-			logrus.WithContext(ctx).Debugf("record %d Status is %d, skip", w.record.ID, w.record.Status)
-			return nil
-		}
+		// 数据库中已经是运行状态时也要更新，清除上次的错误原因
 		logrus.WithContext(ctx).Debugf("w.record.ID is %d, status is %d", w.record.ID, w.record.Status)
-		w.record.Status = dao.RecordStatusRunning
-		err := dao.NewClient().UpdateRecordStatus(ctx, w.record.ID, w.record.Status)
+		w.updateRecord(func(r *dao.Record) {
+			r.Status = dao.RecordStatusRunning
+			r.Reason = ""
+		})
+		err := dao.NewClient().UpdateRecordStatus(ctx, w.record.ID, dao.RecordStatusRunning)
 		if err != nil {
 			logrus.WithContext(ctx).Errorf("UpdateRecordStatus fail, err: %v", err)
 			return err
@@ -0,0 +1,698 @@
+package watch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ink19/poewatcher/config"
+	"github.com/ink19/poewatcher/logic/dao"
+	"github.com/ink19/poewatcher/logic/poetrader"
+	"github.com/ink19/poewatcher/logic/poetrader/poetradertest"
+	"github.com/ink19/poewatcher/logic/pricing"
+	"github.com/ink19/poewatcher/pkg/notify"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const testTimeout = 10 * time.Second
+
+// 配置是全局的，所有测试共用一个假站点和通知服务，用不同的搜索ID区分
+var (
+	tradeSrv   *poetradertest.Server
+	notifyMsgs = make(chan string, 100)
+)
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "poewatcher-watch")
+	if err != nil {
+		panic(err)
+	}
+	tradeSrv = poetradertest.NewServer()
+	notifySrv := newNotifyServer(notifyMsgs)
+
+	cfg := config.Get()
+	cfg.DB.Path = filepath.Join(dir, "test.db")
+	cfg.Notify.URL = notifySrv.URL
+	cfg.Poe.Realms = map[string]config.Realm{"test": tradeSrv.ConfigRealm()}
+	cfg.Poe.PollInterval = 1
+	cfg.Poe.BatchWindow = 50
+
+	code := m.Run()
+	tradeSrv.Close()
+	notifySrv.Close()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// newNotifyServer 模拟企业微信机器人，收到的文本写入msgs
+func newNotifyServer(msgs chan string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		msg := struct {
+			Text struct {
+				Content string `json:"content"`
+			} `json:"text"`
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(&msg); err == nil {
+			select {
+			case msgs <- msg.Text.Content:
+			default:
+			}
+		}
+		_, _ = w.Write([]byte(`{"errcode":0}`))
+	}))
+}
+
+func newTestRecord(t *testing.T, searchID string) *dao.Record {
+	return &dao.Record{
+		Name:     t.Name(),
+		SeasonID: "Standard",
+		SearchID: searchID,
+		Cookie:   "POESESSID=" + t.Name(),
+		Realm:    "test",
+	}
+}
+
+func runWatcher(t *testing.T, record *dao.Record) Watcher {
+	t.Helper()
+	w := New(record)
+	if err := w.Run(); err != nil {
+		t.Fatalf("Run fail, err: %v", err)
+	}
+	t.Cleanup(w.Delete)
+	return w
+}
+
+// nextLive 等待指定搜索的直播连接，跳过其他测试遗留的连接
+func nextLive(t *testing.T, searchID string) *poetradertest.LiveConn {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	for {
+		conn, err := tradeSrv.NextLive(ctx)
+		if err != nil {
+			t.Fatalf("wait live connection %s fail, err: %v", searchID, err)
+		}
+		if conn.SearchID == searchID {
+			return conn
+		}
+	}
+}
+
+func waitMsg(t *testing.T, want string) {
+	t.Helper()
+	timeout := time.After(testTimeout)
+	for {
+		select {
+		case msg := <-notifyMsgs:
+			if msg == want {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("wait notify %q timeout", want)
+		}
+	}
+}
+
+func noMsg(t *testing.T, unwanted string, wait time.Duration) {
+	t.Helper()
+	timeout := time.After(wait)
+	for {
+		select {
+		case msg := <-notifyMsgs:
+			if msg == unwanted {
+				t.Fatalf("unexpected notify %q", msg)
+			}
+		case <-timeout:
+			return
+		}
+	}
+}
+
+// waitRecord 从数据库读取记录，避免和watcher的goroutine竞争
+func waitRecord(t *testing.T, id int64, cond func(r *dao.Record) bool) *dao.Record {
+	t.Helper()
+	deadline := time.Now().Add(testTimeout)
+	for {
+		r, err := dao.NewClient().GetRecord(context.Background(), id)
+		if err != nil {
+			t.Fatalf("GetRecord fail, err: %v", err)
+		}
+		if r != nil && cond(r) {
+			return r
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("wait record %d timeout, record: %+v", id, r)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func waitState(t *testing.T, w Watcher, want State) Status {
+	t.Helper()
+	deadline := time.Now().Add(testTimeout)
+	for {
+		status := w.Status()
+		if status.State == want {
+			return status
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("wait state %s timeout, status: %+v", want, status)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestWatchRecordNotifiesPushedGoods(t *testing.T) {
+	tradeSrv.AddGoods(poetradertest.NewGood("push-a", "item push-a"))
+	runWatcher(t, newTestRecord(t, "push"))
+
+	conn := nextLive(t, "push")
+	if err := conn.Push("push-a"); err != nil {
+		t.Fatalf("Push fail, err: %v", err)
+	}
+	waitMsg(t, "item push-a")
+}
+
+func TestWatchRecordStopsOnUnauthorizedFetch(t *testing.T) {
+	w := runWatcher(t, newTestRecord(t, "unauthorized"))
+	id := w.Record().ID
+
+	conn := nextLive(t, "unauthorized")
+	tradeSrv.Enqueue(poetradertest.EndpointFetch, poetradertest.Status(401))
+	if err := conn.Push("unauthorized-a"); err != nil {
+		t.Fatalf("Push fail, err: %v", err)
+	}
+	r := waitRecord(t, id, func(r *dao.Record) bool { return r.Status == dao.RecordStatusError })
+	if r.Reason == "" {
+		t.Errorf("error reason is empty")
+	}
+	if status := waitState(t, w, StateErrored); status.LastError == "" || status.LastErrorAt == nil {
+		t.Errorf("status = %+v, want last error", status)
+	}
+	select {
+	case <-conn.Done():
+	case <-time.After(testTimeout):
+		t.Errorf("live connection not closed")
+	}
+}
+
+func TestWatchRecordSearchAgainWhenExpired(t *testing.T) {
+	record := newTestRecord(t, "expired-old")
+	record.Query = json.RawMessage(`{"query":{"status":{"option":"online"}}}`)
+	tradeSrv.SetSearch("expired-new")
+	tradeSrv.Enqueue(poetradertest.EndpointLive, poetradertest.Status(404))
+	w := runWatcher(t, record)
+
+	nextLive(t, "expired-new")
+	waitRecord(t, w.Record().ID, func(r *dao.Record) bool { return r.SearchID == "expired-new" })
+}
+
+func TestWatchRecordPollMode(t *testing.T) {
+	record := newTestRecord(t, "")
+	record.Query = json.RawMessage(`{"query":{"status":{"option":"online"}},"sort":{"indexed":"desc"}}`)
+	record.Mode = dao.RecordModePoll
+	tradeSrv.AddGoods(
+		poetradertest.NewGood("poll-a", "item poll-a"),
+		poetradertest.NewGood("poll-b", "item poll-b"),
+	)
+	tradeSrv.SetSearch("poll", "poll-a")
+	before := tradeSrv.Requests(poetradertest.EndpointSearch)
+	runWatcher(t, record)
+
+	// 第一次搜索的结果只记录不通知
+	deadline := time.Now().Add(testTimeout)
+	for tradeSrv.Requests(poetradertest.EndpointSearch) == before {
+		if time.Now().After(deadline) {
+			t.Fatalf("wait first poll timeout")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	tradeSrv.SetSearch("poll", "poll-b", "poll-a")
+	waitMsg(t, "item poll-b")
+	noMsg(t, "item poll-a", 1500*time.Millisecond)
+}
+
+func TestWatcherStop(t *testing.T) {
+	w := runWatcher(t, newTestRecord(t, "stop"))
+	conn := nextLive(t, "stop")
+
+	w.Stop()
+	select {
+	case <-conn.Done():
+	case <-time.After(testTimeout):
+		t.Fatalf("live connection not closed after Stop")
+	}
+	waitRecord(t, w.Record().ID, func(r *dao.Record) bool { return r.Status == dao.RecordStatusPending })
+}
+
+func TestWatchRecordSuppressesNotifiedGoods(t *testing.T) {
+	tradeSrv.AddGoods(
+		poetradertest.NewGood("dup-a", "item dup-a"),
+		poetradertest.NewGood("dup-b", "item dup-b"),
+	)
+	w := runWatcher(t, newTestRecord(t, "dup"))
+
+	conn := nextLive(t, "dup")
+	if err := conn.Push("dup-a", "dup-a"); err != nil {
+		t.Fatalf("Push fail, err: %v", err)
+	}
+	waitMsg(t, "item dup-a")
+	noMsg(t, "item dup-a", 300*time.Millisecond)
+
+	// 重连后同一物品不再通知
+	conn.Disconnect()
+	conn = nextLive(t, "dup")
+	if err := conn.Push("dup-a", "dup-b"); err != nil {
+		t.Fatalf("Push fail, err: %v", err)
+	}
+	waitMsg(t, "item dup-b")
+
+	// 重启后同样不再通知
+	w.Stop()
+	if err := w.Run(); err != nil {
+		t.Fatalf("Run fail, err: %v", err)
+	}
+	conn = nextLive(t, "dup")
+	if err := conn.Push("dup-a", "dup-b"); err != nil {
+		t.Fatalf("Push fail, err: %v", err)
+	}
+	noMsg(t, "item dup-a", 300*time.Millisecond)
+}
+
+func TestWatcherStateTransitions(t *testing.T) {
+	w := New(newTestRecord(t, "state"))
+	if status := w.Status(); status.State != StatePaused {
+		t.Fatalf("initial state = %s, want paused", status.State)
+	}
+	// 没有运行时Stop不会出错
+	w.Stop()
+	if err := w.Run(); err != nil {
+		t.Fatalf("Run fail, err: %v", err)
+	}
+	defer w.Delete()
+	conn := nextLive(t, "state")
+	if status := waitState(t, w, StateLive); status.StartedAt == nil {
+		t.Errorf("started_at is nil")
+	}
+
+	// 重复Run不会建立新的连接
+	if err := w.Run(); err != nil {
+		t.Fatalf("Run again fail, err: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	for {
+		extra, err := tradeSrv.NextLive(ctx)
+		if err != nil {
+			break
+		}
+		if extra.SearchID == "state" {
+			t.Fatalf("Run on running watcher opened another connection")
+		}
+	}
+
+	conn.Disconnect()
+	waitState(t, w, StateReconnecting)
+	nextLive(t, "state")
+	waitState(t, w, StateLive)
+
+	w.Stop()
+	w.Stop()
+	if status := w.Status(); status.State != StatePaused {
+		t.Errorf("state after Stop = %s, want paused", status.State)
+	}
+	waitRecord(t, w.Record().ID, func(r *dao.Record) bool { return r.Status == dao.RecordStatusPending })
+
+	w.Delete()
+	w.Delete()
+	if status := w.Status(); status.State != StateStopped {
+		t.Errorf("state after Delete = %s, want stopped", status.State)
+	}
+	if err := w.Run(); !errors.Is(err, ErrStopped) {
+		t.Errorf("Run after Delete err = %v, want ErrStopped", err)
+	}
+}
+
+func TestWatcherFail(t *testing.T) {
+	w := runWatcher(t, newTestRecord(t, "fail"))
+	conn := nextLive(t, "fail")
+	waitState(t, w, StateLive)
+
+	w.Fail(errors.New("cookie expired"))
+	if status := w.Status(); status.State != StateErrored || status.LastError != "cookie expired" {
+		t.Errorf("status = %+v, want errored", status)
+	}
+	select {
+	case <-conn.Done():
+	case <-time.After(testTimeout):
+		t.Fatalf("live connection not closed after Fail")
+	}
+	waitRecord(t, w.Record().ID, func(r *dao.Record) bool { return r.Status == dao.RecordStatusError })
+
+	// 出错后可以重新运行
+	if err := w.Run(); err != nil {
+		t.Fatalf("Run fail, err: %v", err)
+	}
+	nextLive(t, "fail")
+	waitState(t, w, StateLive)
+}
+
+func filterDesc(id string, life int) string {
+	return fmt.Sprintf("Rarity: Rare\n%s\nRuby Ring\n--------\nItem Level: 80\n--------\n+%d to maximum Life\n", id, life)
+}
+
+func newFilterGood(id string, seller string, life int) *poetrader.PoeGood {
+	good := poetradertest.NewGood(id, filterDesc(id, life))
+	good.Listing.Account = &poetrader.PoeAccount{Name: seller}
+	good.Listing.Price = &poetrader.PoePrice{Type: "~price", Amount: 10, Currency: "chaos"}
+	return good
+}
+
+func TestWatchRecordFilter(t *testing.T) {
+	tradeSrv.AddGoods(newFilterGood("flt-a", "good", 95), newFilterGood("flt-b", "spammer", 95), newFilterGood("flt-c", "good", 50))
+	record := newTestRecord(t, "filter")
+	record.Filter = `item.life >= 90 && price.chaos <= 20 && seller.name not in ["spammer"]`
+	w := runWatcher(t, record)
+
+	conn := nextLive(t, "filter")
+	if err := conn.Push("flt-a", "flt-b", "flt-c"); err != nil {
+		t.Fatalf("Push fail, err: %v", err)
+	}
+	waitMsg(t, filterDesc("flt-a", 95))
+	noMsg(t, filterDesc("flt-b", 95), 300*time.Millisecond)
+	noMsg(t, filterDesc("flt-c", 50), 100*time.Millisecond)
+	if status := w.Status(); status.Hits != 3 || status.Dropped != 2 {
+		t.Errorf("hits = %d, dropped = %d, want 3 and 2", status.Hits, status.Dropped)
+	}
+}
+
+func TestValidateFilter(t *testing.T) {
+	if err := ValidateFilter(`item.resistance >= 100 && price.divine <= 2 && has("to maximum Life")`); err != nil {
+		t.Errorf("ValidateFilter fail, err: %v", err)
+	}
+	for _, src := range []string{`item.resistence > 1`, `item.life >`, `seller("a")`} {
+		if err := ValidateFilter(src); err == nil {
+			t.Errorf("ValidateFilter(%q) should fail", src)
+		}
+	}
+}
+
+func TestFilterStatID(t *testing.T) {
+	data := &dao.TradeData{
+		Realm:     "test",
+		Kind:      poetrader.DataStats,
+		Data:      json.RawMessage(`[{"id":"explicit","label":"Explicit","entries":[{"id":"explicit.stat_3299347043","text":"+# to maximum Life","type":"explicit"}]}]`),
+		UpdatedAt: time.Now(),
+	}
+	if err := dao.NewClient().SaveTradeData(context.Background(), data); err != nil {
+		t.Fatalf("SaveTradeData fail, err: %v", err)
+	}
+	expr, err := compileFilter(`mod("explicit.stat_3299347043") >= 90`)
+	if err != nil {
+		t.Fatalf("compileFilter fail, err: %v", err)
+	}
+	for life, want := range map[int]bool{95: true, 50: false} {
+		good := newFilterGood("stat", "seller", life)
+		ok, err := expr.Match(goodEnv(context.Background(), "test", good, nil))
+		if err != nil || ok != want {
+			t.Errorf("life %d match = %v, err: %v, want %v", life, ok, err, want)
+		}
+	}
+}
+
+func TestWatchRecordNotifiers(t *testing.T) {
+	teamMsgs := make(chan string, 10)
+	teamSrv := newNotifyServer(teamMsgs)
+	defer teamSrv.Close()
+	cfg := config.Get()
+	cfg.Notify.Targets = map[string]config.NotifyTarget{"team": {Type: "wxwork", URL: teamSrv.URL}}
+	defer func() {
+		cfg.Notify.Targets = nil
+	}()
+
+	if err := ValidateNotifiers([]string{"team"}); err != nil {
+		t.Errorf("ValidateNotifiers fail, err: %v", err)
+	}
+	if err := ValidateNotifiers([]string{"nobody"}); err == nil {
+		t.Errorf("ValidateNotifiers should fail for unknown notifier")
+	}
+
+	tradeSrv.AddGoods(poetradertest.NewGood("route-a", "item route-a"))
+	record := newTestRecord(t, "route")
+	record.Notifiers = []string{"team"}
+	runWatcher(t, record)
+	conn := nextLive(t, "route")
+	if err := conn.Push("route-a"); err != nil {
+		t.Fatalf("Push fail, err: %v", err)
+	}
+	select {
+	case msg := <-teamMsgs:
+		if msg != "item route-a" {
+			t.Errorf("team notify = %q, want item route-a", msg)
+		}
+	case <-time.After(testTimeout):
+		t.Fatalf("wait team notify timeout")
+	}
+	noMsg(t, "item route-a", 300*time.Millisecond)
+}
+
+func TestWatchRecordTemplate(t *testing.T) {
+	if err := ValidateTemplate(&dao.NotifyTemplate{Text: "{{.Nope}}"}); err == nil {
+		t.Errorf("ValidateTemplate should fail for unknown field")
+	}
+	if err := ValidateTemplate(&dao.NotifyTemplate{Markdown: "{{.Record"}); err == nil {
+		t.Errorf("ValidateTemplate should fail for syntax error")
+	}
+
+	realm, err := poetrader.GetRealm("test")
+	if err != nil {
+		t.Fatalf("GetRealm fail, err: %v", err)
+	}
+	tradeSrv.AddGoods(poetradertest.NewGood("tmpl-a", "item tmpl-a"))
+	record := newTestRecord(t, "tmpl")
+	record.Template = &dao.NotifyTemplate{Text: "{{.Record}}: {{.ItemID}} {{.TradeURL}}"}
+	runWatcher(t, record)
+	conn := nextLive(t, "tmpl")
+	if err := conn.Push("tmpl-a"); err != nil {
+		t.Fatalf("Push fail, err: %v", err)
+	}
+	want := record.Name + ": tmpl-a " + realm.TradeURL("Standard", "tmpl")
+	waitMsg(t, want)
+
+	ctx := context.Background()
+	msg, sample, err := Preview(ctx, record, &dao.NotifyTemplate{Text: "{{.ItemID}} {{.Desc}}"}, false)
+	if err != nil {
+		t.Fatalf("Preview fail, err: %v", err)
+	}
+	if sample || msg.Text != "tmpl-a item tmpl-a" {
+		t.Errorf("Preview = %q, sample: %v, want stored hit", msg.Text, sample)
+	}
+	msg, sample, err = Preview(ctx, record, nil, true)
+	if err != nil {
+		t.Fatalf("Preview sample fail, err: %v", err)
+	}
+	if !sample || !strings.HasPrefix(msg.Text, record.Name+": sample ") || !strings.Contains(msg.Markdown, "Storm Loop") {
+		t.Errorf("Preview sample = %+v, sample: %v", msg, sample)
+	}
+}
+
+func newDigestGood(id string, amount float64) *poetrader.PoeGood {
+	good := poetradertest.NewGood(id, filterDesc(id, 80))
+	good.Listing.Account = &poetrader.PoeAccount{Name: "seller", LastCharacterName: "char-" + id}
+	good.Listing.Price = &poetrader.PoePrice{Type: "~price", Amount: amount, Currency: "chaos"}
+	return good
+}
+
+func TestWatchRecordDigest(t *testing.T) {
+	digestMsgs := make(chan string, 10)
+	digestSrv := newNotifyServer(digestMsgs)
+	defer digestSrv.Close()
+	cfg := config.Get()
+	cfg.Notify.Targets = map[string]config.NotifyTarget{"digest": {Type: "wxwork", URL: digestSrv.URL}}
+	defer func() {
+		cfg.Notify.Targets = nil
+	}()
+	waitDigest := func(want string) {
+		t.Helper()
+		select {
+		case msg := <-digestMsgs:
+			if msg != want {
+				t.Errorf("digest notify = %q, want %q", msg, want)
+			}
+		case <-time.After(testTimeout):
+			t.Fatalf("wait digest notify timeout")
+		}
+	}
+
+	tradeSrv.AddGoods(newDigestGood("dg-a", 30), newDigestGood("dg-b", 10), newDigestGood("dg-c", 20), newDigestGood("dg-d", 5))
+	record := newTestRecord(t, "digest")
+	record.Notifiers = []string{"digest"}
+	record.Digest = &dao.DigestParam{Window: 1, Max: 3, Full: 1}
+	runWatcher(t, record)
+	conn := nextLive(t, "digest")
+
+	// 攒满max个立即发送，价格最低的显示完整内容
+	if err := conn.Push("dg-a", "dg-b", "dg-c"); err != nil {
+		t.Fatalf("Push fail, err: %v", err)
+	}
+	waitDigest(record.Name + " 汇总: 3 个物品\n========\n" + filterDesc("dg-b", 80) +
+		"\n========\n其他 2 个:\ndg-c Ruby Ring 20 chaos @char-dg-c\ndg-a Ruby Ring 30 chaos @char-dg-a")
+
+	// 窗口内只有一个物品时和普通通知相同
+	start := time.Now()
+	if err := conn.Push("dg-d"); err != nil {
+		t.Fatalf("Push fail, err: %v", err)
+	}
+	waitDigest(filterDesc("dg-d", 80))
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("digest sent after %s, want at least 1s", elapsed)
+	}
+
+	if err := ValidateDigest(&dao.DigestParam{Window: -1}); err == nil {
+		t.Errorf("ValidateDigest should fail for negative window")
+	}
+}
+
+func TestSortHits(t *testing.T) {
+	hit := func(id string, amount float64, currency string, chaos float64) *digestHit {
+		good := &poetrader.PoeGood{ID: id}
+		if currency != "" {
+			good.Listing.Price = &poetrader.PoePrice{Amount: amount, Currency: currency}
+		}
+		h := &digestHit{good: good}
+		if chaos > 0 {
+			h.value = &pricing.Value{Amount: amount, Currency: currency, Chaos: chaos}
+		}
+		return h
+	}
+	hits := []*digestHit{
+		hit("none", 0, "", 0),
+		hit("exalted-2", 2, "exalted", 0),
+		hit("chaos-50", 50, "chaos", 50),
+		hit("divine-1", 1, "divine", 0),
+		hit("exalted-1", 1, "exalted", 0),
+		hit("divine-cheap", 0.1, "divine", 20),
+	}
+	sortHits(hits)
+	ids := []string{}
+	for _, h := range hits {
+		ids = append(ids, h.good.ID)
+	}
+	want := "divine-cheap chaos-50 divine-1 exalted-1 exalted-2 none"
+	if got := strings.Join(ids, " "); got != want {
+		t.Errorf("sortHits = %s, want %s", got, want)
+	}
+}
+
+// flakyNotifier 前fails次发送失败，成功发送的消息写入sent
+type flakyNotifier struct {
+	fails int
+	sent  []string
+}
+
+func (n *flakyNotifier) Send(ctx context.Context, msg *notify.Message) error {
+	if n.fails > 0 {
+		n.fails--
+		return errors.New("send fail")
+	}
+	n.sent = append(n.sent, msg.Text)
+	return nil
+}
+
+func TestNotifyExchangeRetriesFailedSend(t *testing.T) {
+	w := &watcher{record: newTestRecord(t, "")}
+	param := &dao.ExchangeParam{Have: []string{"chaos"}, Want: []string{"divine"}, Ratio: 200}
+	res := &poetrader.ExchangeRes{Result: map[string]*poetrader.ExchangeResult{
+		"a": {ID: "a", Listing: &poetrader.ExchangeListing{Offers: []*poetrader.ExchangeOffer{{
+			Exchange: poetrader.ExchangeCurrency{Currency: "chaos", Amount: 150},
+			Item:     poetrader.ExchangeCurrency{Currency: "divine", Amount: 1},
+		}}}},
+	}}
+	n := &flakyNotifier{fails: 1}
+	ctx := context.Background()
+
+	// 发送失败的报价下一轮重新通知，成功后不再重复
+	notified := w.notifyExchange(ctx, n, param, res, map[string]struct{}{})
+	if len(notified) != 0 || len(n.sent) != 0 {
+		t.Fatalf("notified = %v, sent = %v, want nothing after failed send", notified, n.sent)
+	}
+	notified = w.notifyExchange(ctx, n, param, res, notified)
+	notified = w.notifyExchange(ctx, n, param, res, notified)
+	if len(notified) != 1 || len(n.sent) != 1 {
+		t.Errorf("notified = %v, sent = %v, want one notify", notified, n.sent)
+	}
+}
+
+func TestAccountCookieOverride(t *testing.T) {
+	cfg := config.Get()
+	cfg.Poe.Accounts = map[string]config.Account{"pool": {Cookie: "POESESSID=old", Proxy: "http://127.0.0.1:1"}}
+	defer func() {
+		cfg.Poe.Accounts = nil
+	}()
+
+	setAccountCookie("pool", "POESESSID=new")
+	account, ok := Account("pool")
+	if !ok || account.Cookie != "POESESSID=new" || account.Proxy != "http://127.0.0.1:1" {
+		t.Errorf("Account = %+v, %v, want replaced cookie", account, ok)
+	}
+	if cookie := cfg.Poe.Accounts["pool"].Cookie; cookie != "POESESSID=old" {
+		t.Errorf("config cookie = %s, want unchanged", cookie)
+	}
+	if _, ok := Account("nobody"); ok {
+		t.Errorf("Account should not find unknown account")
+	}
+}
+
+func TestWatchRecordRetriesFailedNotify(t *testing.T) {
+	msgs := make(chan string, 10)
+	var fail atomic.Bool
+	fail.Store(true)
+	inner := newNotifyServer(msgs)
+	defer inner.Close()
+	// 第一次返回限频错误
+	flakySrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail.CompareAndSwap(true, false) {
+			_, _ = w.Write([]byte(`{"errcode":45009,"errmsg":"api freq out of limit"}`))
+			return
+		}
+		inner.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer flakySrv.Close()
+	cfg := config.Get()
+	cfg.Notify.Targets = map[string]config.NotifyTarget{"flaky": {Type: "wxwork", URL: flakySrv.URL}}
+	defer func() {
+		cfg.Notify.Targets = nil
+	}()
+
+	tradeSrv.AddGoods(poetradertest.NewGood("retry-a", "item retry-a"))
+	record := newTestRecord(t, "retry")
+	record.Notifiers = []string{"flaky"}
+	runWatcher(t, record)
+	conn := nextLive(t, "retry")
+	if err := conn.Push("retry-a"); err != nil {
+		t.Fatalf("Push fail, err: %v", err)
+	}
+	// 发送失败的物品没有标记，再次推送时重新通知
+	time.Sleep(300 * time.Millisecond)
+	if err := conn.Push("retry-a"); err != nil {
+		t.Fatalf("Push fail, err: %v", err)
+	}
+	select {
+	case msg := <-msgs:
+		if msg != "item retry-a" {
+			t.Errorf("notify = %q, want item retry-a", msg)
+		}
+	case <-time.After(testTimeout):
+		t.Fatalf("wait notify timeout")
+	}
+}
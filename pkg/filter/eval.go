@@ -0,0 +1,373 @@
+package filter
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Env 表达式可以访问的变量，值可以是数字、字符串、bool、nil、列表、嵌套的Env和Func
+type Env map[string]interface{}
+
+// Func 表达式中可以调用的函数
+type Func func(args ...interface{}) (interface{}, error)
+
+var errNotFunc = errors.New("not a function")
+
+// Eval 求值并返回结果
+func (e *Expr) Eval(env Env) (interface{}, error) {
+	return eval(e.root, env)
+}
+
+// Match 求值并按真值返回，nil、false、0和空字符串为假
+func (e *Expr) Match(env Env) (bool, error) {
+	v, err := e.Eval(env)
+	if err != nil {
+		return false, err
+	}
+	return truthy(v), nil
+}
+
+// Check 检查表达式中的变量、成员和函数在env中都存在，用于提前发现拼写错误
+// env中的值只用来确定结构，不会调用函数
+func (e *Expr) Check(env Env) error {
+	return check(e.root, env)
+}
+
+func check(n node, env Env) error {
+	switch n := n.(type) {
+	case *literal:
+		return nil
+	case *ident, *member:
+		_, err := resolve(n, env)
+		return err
+	case *call:
+		fn, err := resolve(n.fn, env)
+		if err != nil {
+			return err
+		}
+		if _, ok := fn.(Func); !ok {
+			return fmt.Errorf("%s is %w", name(n.fn), errNotFunc)
+		}
+		for _, arg := range n.args {
+			if err := check(arg, env); err != nil {
+				return err
+			}
+		}
+		return nil
+	case *list:
+		for _, item := range n.items {
+			if err := check(item, env); err != nil {
+				return err
+			}
+		}
+		return nil
+	case *unary:
+		return check(n.operand, env)
+	case *binary:
+		if err := check(n.left, env); err != nil {
+			return err
+		}
+		return check(n.right, env)
+	}
+	return fmt.Errorf("unknown node %T", n)
+}
+
+// resolve 查找变量和成员，不存在时返回错误
+func resolve(n node, env Env) (interface{}, error) {
+	switch n := n.(type) {
+	case *ident:
+		v, ok := env[n.name]
+		if !ok {
+			return nil, fmt.Errorf("unknown variable %s", n.name)
+		}
+		return normalize(v), nil
+	case *member:
+		obj, err := resolve(n.object, env)
+		if err != nil {
+			return nil, err
+		}
+		m, ok := obj.(Env)
+		if !ok {
+			return nil, fmt.Errorf("%s has no field %s", name(n.object), n.name)
+		}
+		v, ok := m[n.name]
+		if !ok {
+			return nil, fmt.Errorf("unknown field %s.%s", name(n.object), n.name)
+		}
+		return normalize(v), nil
+	}
+	return eval(n, env)
+}
+
+func name(n node) string {
+	switch n := n.(type) {
+	case *ident:
+		return n.name
+	case *member:
+		return name(n.object) + "." + n.name
+	}
+	return "expression"
+}
+
+// normalize 把整数和字符串列表转换为表达式使用的类型
+func normalize(v interface{}) interface{} {
+	switch v := v.(type) {
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case float32:
+		return float64(v)
+	case map[string]interface{}:
+		return Env(v)
+	case func(args ...interface{}) (interface{}, error):
+		return Func(v)
+	case []string:
+		res := make([]interface{}, 0, len(v))
+		for _, s := range v {
+			res = append(res, s)
+		}
+		return res
+	case []float64:
+		res := make([]interface{}, 0, len(v))
+		for _, f := range v {
+			res = append(res, f)
+		}
+		return res
+	}
+	return v
+}
+
+func eval(n node, env Env) (interface{}, error) {
+	switch n := n.(type) {
+	case *literal:
+		return n.value, nil
+	case *ident, *member:
+		return resolve(n, env)
+	case *call:
+		fn, err := resolve(n.fn, env)
+		if err != nil {
+			return nil, err
+		}
+		f, ok := fn.(Func)
+		if !ok {
+			return nil, fmt.Errorf("%s is %w", name(n.fn), errNotFunc)
+		}
+		args := make([]interface{}, 0, len(n.args))
+		for _, arg := range n.args {
+			v, err := eval(arg, env)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, v)
+		}
+		v, err := f(args...)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name(n.fn), err)
+		}
+		return normalize(v), nil
+	case *list:
+		items := make([]interface{}, 0, len(n.items))
+		for _, item := range n.items {
+			v, err := eval(item, env)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, v)
+		}
+		return items, nil
+	case *unary:
+		v, err := eval(n.operand, env)
+		if err != nil {
+			return nil, err
+		}
+		if n.op == "!" {
+			return !truthy(v), nil
+		}
+		if v == nil {
+			return nil, nil
+		}
+		f, ok := v.(float64)
+		if !ok {
+			return nil, fmt.Errorf("cannot negate %T", v)
+		}
+		return -f, nil
+	case *binary:
+		return evalBinary(n, env)
+	}
+	return nil, fmt.Errorf("unknown node %T", n)
+}
+
+func evalBinary(n *binary, env Env) (interface{}, error) {
+	left, err := eval(n.left, env)
+	if err != nil {
+		return nil, err
+	}
+	// 短路求值
+	switch n.op {
+	case "&&":
+		if !truthy(left) {
+			return false, nil
+		}
+		right, err := eval(n.right, env)
+		return truthy(right), err
+	case "||":
+		if truthy(left) {
+			return true, nil
+		}
+		right, err := eval(n.right, env)
+		return truthy(right), err
+	}
+
+	right, err := eval(n.right, env)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "==":
+		return equal(left, right), nil
+	case "!=":
+		return !equal(left, right), nil
+	case "in":
+		return contains(right, left)
+	case "not in":
+		ok, err := contains(right, left)
+		return !ok, err
+	case "contains":
+		return contains(left, right)
+	case "<", "<=", ">", ">=":
+		return compare(n.op, left, right)
+	case "+", "-", "*", "/":
+		return arith(n.op, left, right)
+	}
+	return nil, fmt.Errorf("unknown operator %s", n.op)
+}
+
+func truthy(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	case string:
+		return v != ""
+	case []interface{}:
+		return len(v) > 0
+	}
+	return true
+}
+
+func equal(a, b interface{}) bool {
+	switch a := a.(type) {
+	case string:
+		b, ok := b.(string)
+		return ok && a == b
+	case float64:
+		b, ok := b.(float64)
+		return ok && a == b
+	case bool:
+		b, ok := b.(bool)
+		return ok && a == b
+	case nil:
+		return b == nil
+	}
+	return false
+}
+
+// contains 列表中有相等的元素，或者字符串包含子串，字符串比较不区分大小写
+func contains(container, v interface{}) (bool, error) {
+	switch c := container.(type) {
+	case nil:
+		return false, nil
+	case []interface{}:
+		for _, item := range c {
+			if s, ok := item.(string); ok {
+				if vs, ok := v.(string); ok && strings.EqualFold(s, vs) {
+					return true, nil
+				}
+				continue
+			}
+			if equal(item, v) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case string:
+		s, ok := v.(string)
+		if !ok {
+			return false, fmt.Errorf("cannot search %T in string", v)
+		}
+		return strings.Contains(strings.ToLower(c), strings.ToLower(s)), nil
+	}
+	return false, fmt.Errorf("cannot search in %T", container)
+}
+
+// compare 任一边为nil时返回false，比如物品没有标价
+func compare(op string, a, b interface{}) (bool, error) {
+	if a == nil || b == nil {
+		return false, nil
+	}
+	var c int
+	switch a := a.(type) {
+	case float64:
+		bf, ok := b.(float64)
+		if !ok {
+			return false, fmt.Errorf("cannot compare number with %T", b)
+		}
+		switch {
+		case a < bf:
+			c = -1
+		case a > bf:
+			c = 1
+		}
+	case string:
+		bs, ok := b.(string)
+		if !ok {
+			return false, fmt.Errorf("cannot compare string with %T", b)
+		}
+		c = strings.Compare(a, bs)
+	default:
+		return false, fmt.Errorf("cannot compare %T", a)
+	}
+	switch op {
+	case "<":
+		return c < 0, nil
+	case "<=":
+		return c <= 0, nil
+	case ">":
+		return c > 0, nil
+	}
+	return c >= 0, nil
+}
+
+// arith 任一边为nil时结果为nil，字符串只支持+
+func arith(op string, a, b interface{}) (interface{}, error) {
+	if a == nil || b == nil {
+		return nil, nil
+	}
+	if as, ok := a.(string); ok && op == "+" {
+		if bs, ok := b.(string); ok {
+			return as + bs, nil
+		}
+	}
+	af, aok := a.(float64)
+	bf, bok := b.(float64)
+	if !aok || !bok {
+		return nil, fmt.Errorf("cannot apply %s to %T and %T", op, a, b)
+	}
+	switch op {
+	case "+":
+		return af + bf, nil
+	case "-":
+		return af - bf, nil
+	case "*":
+		return af * bf, nil
+	}
+	if bf == 0 {
+		return nil, fmt.Errorf("division by zero")
+	}
+	return af / bf, nil
+}
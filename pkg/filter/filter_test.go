@@ -0,0 +1,113 @@
+package filter
+
+import (
+	"strings"
+	"testing"
+)
+
+func testEnv() Env {
+	return Env{
+		"item": Env{
+			"name":       "Doom Shell",
+			"ilvl":       86,
+			"resistance": 105.0,
+			"influences": []string{"shaper"},
+			"corrupted":  false,
+		},
+		"price": Env{
+			"divine": 1.5,
+			"chaos":  nil,
+		},
+		"seller": Env{
+			"name": "Bob",
+		},
+		"mod": Func(func(args ...interface{}) (interface{}, error) {
+			if len(args) == 1 && args[0] == "to maximum Life" {
+				return 95.0, nil
+			}
+			return nil, nil
+		}),
+	}
+}
+
+func TestMatch(t *testing.T) {
+	cases := []struct {
+		src  string
+		want bool
+	}{
+		{`item.resistance >= 100 && price.divine <= 2 && seller.name not in ["alice", "eve"]`, true},
+		{`item.resistance >= 100 and price.divine <= 1`, false},
+		{`seller.name in ["BOB"]`, true},
+		{`not item.corrupted`, true},
+		{`!(item.ilvl > 85)`, false},
+		{`"shaper" in item.influences`, true},
+		{`item.name contains "doom"`, true},
+		{`price.chaos < 100`, false},
+		{`price.chaos == null`, true},
+		{`mod("to maximum Life") + 5 == 100`, true},
+		{`mod("unknown") > 0 || item.ilvl - 6 * 2 / 4 == 83`, true},
+		{`-item.ilvl < 0`, true},
+	}
+	env := testEnv()
+	for _, c := range cases {
+		expr, err := Parse(c.src)
+		if err != nil {
+			t.Errorf("Parse(%q) fail, err: %v", c.src, err)
+			continue
+		}
+		if err := expr.Check(env); err != nil {
+			t.Errorf("Check(%q) fail, err: %v", c.src, err)
+		}
+		got, err := expr.Match(env)
+		if err != nil {
+			t.Errorf("Match(%q) fail, err: %v", c.src, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("Match(%q) = %v, want %v", c.src, got, c.want)
+		}
+	}
+}
+
+func TestParseError(t *testing.T) {
+	for _, src := range []string{``, `item.ilvl >`, `(item.ilvl > 1`, `item.ilvl not 1`, `"abc`, `item.ilvl # 1`, `a b`} {
+		if _, err := Parse(src); err == nil {
+			t.Errorf("Parse(%q) should fail", src)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	env := testEnv()
+	cases := map[string]string{
+		`item.ilvl > 1 || item.levle > 1`: "unknown field item.levle",
+		`sellr.name == "a"`:               "unknown variable sellr",
+		`item.name.first == "a"`:          "item.name has no field first",
+		`item.name("a")`:                  "not a function",
+	}
+	for src, want := range cases {
+		expr, err := Parse(src)
+		if err != nil {
+			t.Fatalf("Parse(%q) fail, err: %v", src, err)
+		}
+		if err := expr.Check(env); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Check(%q) err = %v, want %q", src, err, want)
+		}
+	}
+}
+
+func TestEvalError(t *testing.T) {
+	cases := map[string]string{
+		`item.name > 1`:        "cannot compare string with float64",
+		`item.ilvl > "eighty"`: "cannot compare number with string",
+	}
+	for src, want := range cases {
+		expr, err := Parse(src)
+		if err != nil {
+			t.Fatalf("Parse(%q) fail, err: %v", src, err)
+		}
+		if _, err := expr.Match(testEnv()); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Match(%q) err = %v, want %q", src, err, want)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+// Package itemtext 解析游戏内Ctrl+C复制的物品文本，支持国服中文和国际服英文
+//
+// 文本按 -------- 分成多段，第一段是类别、稀有度和名称，之后依次是属性、需求、插槽、
+// 物品等级、词缀以及腐化、影响等标记。交易接口的extended.text就是这种格式
+package itemtext
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type Rarity string
+
+const (
+	RarityNormal         Rarity = "normal"
+	RarityMagic          Rarity = "magic"
+	RarityRare           Rarity = "rare"
+	RarityUnique         Rarity = "unique"
+	RarityCurrency       Rarity = "currency"
+	RarityGem            Rarity = "gem"
+	RarityDivinationCard Rarity = "divination_card"
+	RarityUnknown        Rarity = "unknown"
+)
+
+type ModKind string
+
+const (
+	ModEnchant   ModKind = "enchant"
+	ModImplicit  ModKind = "implicit"
+	ModExplicit  ModKind = "explicit"
+	ModCrafted   ModKind = "crafted"
+	ModFractured ModKind = "fractured"
+)
+
+// 影响统一使用英文标识
+const (
+	InfluenceShaper        = "shaper"
+	InfluenceElder         = "elder"
+	InfluenceCrusader      = "crusader"
+	InfluenceRedeemer      = "redeemer"
+	InfluenceHunter        = "hunter"
+	InfluenceWarlord       = "warlord"
+	InfluenceSearingExarch = "searing_exarch"
+	InfluenceEaterOfWorlds = "eater_of_worlds"
+)
+
+type Mod struct {
+	Kind ModKind `json:"kind"`
+	// Text 去掉 (crafted) 等后缀的原文
+	Text string `json:"text"`
+	// Template 数字替换为#，和交易站点的词缀文本格式一致，比如 +#% to all Elemental Resistances
+	Template string    `json:"template"`
+	Values   []float64 `json:"values,omitempty"`
+}
+
+type Requirements struct {
+	Level int `json:"level,omitempty"`
+	Str   int `json:"str,omitempty"`
+	Dex   int `json:"dex,omitempty"`
+	Int   int `json:"int,omitempty"`
+}
+
+type Property struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type Item struct {
+	Class  string `json:"class,omitempty"`
+	Rarity Rarity `json:"rarity"`
+	// Name 稀有和传奇物品的名称，其他物品和Base相同
+	Name      string `json:"name"`
+	Base      string `json:"base"`
+	ItemLevel int    `json:"item_level,omitempty"`
+	Quality   int    `json:"quality,omitempty"`
+	// Sockets 原始的插槽文本，比如 R-R-G-B B-R
+	Sockets      string       `json:"sockets,omitempty"`
+	Links        int          `json:"links,omitempty"`
+	Requirements Requirements `json:"requirements"`
+	Properties   []Property   `json:"properties,omitempty"`
+
+	Enchants   []*Mod   `json:"enchants,omitempty"`
+	Implicits  []*Mod   `json:"implicits,omitempty"`
+	Explicits  []*Mod   `json:"explicits,omitempty"`
+	Crafted    []*Mod   `json:"crafted,omitempty"`
+	Fractured  []*Mod   `json:"fractured,omitempty"`
+	Influences []string `json:"influences,omitempty"`
+
+	Corrupted    bool `json:"corrupted,omitempty"`
+	Mirrored     bool `json:"mirrored,omitempty"`
+	Unidentified bool `json:"unidentified,omitempty"`
+	// Note 卖家的标价备注
+	Note string `json:"note,omitempty"`
+}
+
+// Mods 返回所有词缀，顺序为附魔、基底、破裂、普通、工艺
+func (it *Item) Mods() []*Mod {
+	res := []*Mod{}
+	res = append(res, it.Enchants...)
+	res = append(res, it.Implicits...)
+	res = append(res, it.Fractured...)
+	res = append(res, it.Explicits...)
+	res = append(res, it.Crafted...)
+	return res
+}
+
+var numberRe = regexp.MustCompile(`\d+(?:\.\d+)?`)
+
+// NewMod 提取文本中的数字，-在开头或者空格、+、(之后时为负号，在数字之后时为范围，比如 +45(40-50)%
+func NewMod(kind ModKind, text string) *Mod {
+	mod := &Mod{Kind: kind, Text: text}
+	for _, loc := range numberRe.FindAllStringIndex(text, -1) {
+		v, err := strconv.ParseFloat(text[loc[0]:loc[1]], 64)
+		if err != nil {
+			continue
+		}
+		if isNegative(text, loc[0]) {
+			v = -v
+		}
+		mod.Values = append(mod.Values, v)
+	}
+	mod.Template = numberRe.ReplaceAllString(text, "#")
+	return mod
+}
+
+func isNegative(text string, start int) bool {
+	if start == 0 || text[start-1] != '-' {
+		return false
+	}
+	if start == 1 {
+		return true
+	}
+	switch text[start-2] {
+	case ' ', '+', '(':
+		return true
+	}
+	return false
+}
+
+func (it *Item) addMod(mod *Mod) {
+	switch mod.Kind {
+	case ModEnchant:
+		it.Enchants = append(it.Enchants, mod)
+	case ModImplicit:
+		it.Implicits = append(it.Implicits, mod)
+	case ModCrafted:
+		it.Crafted = append(it.Crafted, mod)
+	case ModFractured:
+		it.Fractured = append(it.Fractured, mod)
+	default:
+		it.Explicits = append(it.Explicits, mod)
+	}
+}
+
+// parseSockets 返回最大连接数
+func parseSockets(sockets string) int {
+	links := 0
+	for _, group := range strings.Fields(sockets) {
+		if n := len(strings.Split(group, "-")); n > links {
+			links = n
+		}
+	}
+	return links
+}
@@ -0,0 +1,337 @@
+package itemtext
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const separator = "--------"
+
+var ErrEmpty = errors.New("empty item text")
+
+// 以下关键字同时包含英文和国服中文，键名比较前会去掉空格
+var (
+	keyClass        = []string{"Item Class", "物品类别"}
+	keyRarity       = []string{"Rarity", "稀有度"}
+	keyQuality      = []string{"Quality", "品质"}
+	keyRequirements = []string{"Requirements", "需求"}
+	keyLevel        = []string{"Level", "等级"}
+	keyStr          = []string{"Str", "Strength", "力量"}
+	keyDex          = []string{"Dex", "Dexterity", "敏捷"}
+	keyInt          = []string{"Int", "Intelligence", "智慧"}
+	keySockets      = []string{"Sockets", "插槽"}
+	keyItemLevel    = []string{"Item Level", "物品等级"}
+	keyNote         = []string{"Note", "备注"}
+
+	flagCorrupted    = []string{"Corrupted", "已腐化"}
+	flagMirrored     = []string{"Mirrored", "已复制", "镜像"}
+	flagUnidentified = []string{"Unidentified", "未鉴定"}
+
+	rarities = map[string]Rarity{
+		"Normal":          RarityNormal,
+		"普通":              RarityNormal,
+		"Magic":           RarityMagic,
+		"魔法":              RarityMagic,
+		"Rare":            RarityRare,
+		"稀有":              RarityRare,
+		"Unique":          RarityUnique,
+		"传奇":              RarityUnique,
+		"Currency":        RarityCurrency,
+		"通货":              RarityCurrency,
+		"Gem":             RarityGem,
+		"宝石":              RarityGem,
+		"Divination Card": RarityDivinationCard,
+		"命运卡":             RarityDivinationCard,
+	}
+
+	influences = map[string]string{
+		"Shaper Item":          InfluenceShaper,
+		"塑界者之物":                InfluenceShaper,
+		"塑界者物品":                InfluenceShaper,
+		"Elder Item":           InfluenceElder,
+		"裂界者之物":                InfluenceElder,
+		"裂界者物品":                InfluenceElder,
+		"Crusader Item":        InfluenceCrusader,
+		"圣战军王物品":               InfluenceCrusader,
+		"Redeemer Item":        InfluenceRedeemer,
+		"救赎者物品":                InfluenceRedeemer,
+		"Hunter Item":          InfluenceHunter,
+		"狩猎者物品":                InfluenceHunter,
+		"Warlord Item":         InfluenceWarlord,
+		"总督军物品":                InfluenceWarlord,
+		"Searing Exarch Item":  InfluenceSearingExarch,
+		"灼烧总督物品":               InfluenceSearingExarch,
+		"Eater of Worlds Item": InfluenceEaterOfWorlds,
+		"吞噬天地物品":               InfluenceEaterOfWorlds,
+	}
+
+	// 国服复制的文本中词缀后缀一般也是英文，同时兼容中文后缀
+	modSuffixes = map[string]ModKind{
+		"(implicit)":  ModImplicit,
+		"(固有)":        ModImplicit,
+		"(enchant)":   ModEnchant,
+		"(附魔)":        ModEnchant,
+		"(crafted)":   ModCrafted,
+		"(工艺)":        ModCrafted,
+		"(fractured)": ModFractured,
+		"(分裂)":        ModFractured,
+	}
+
+	// 新版本的需求格式：Requires Level 62, 180 Str
+	requiresRe = regexp.MustCompile(`^Requires (.+)$`)
+	intRe      = regexp.MustCompile(`-?\d+`)
+)
+
+// Parse 解析复制的物品文本，无法识别的段落会被忽略
+func Parse(text string) (*Item, error) {
+	sections := splitSections(text)
+	if len(sections) == 0 || len(sections[0]) == 0 {
+		return nil, ErrEmpty
+	}
+
+	it := &Item{Rarity: RarityUnknown}
+	parseHeader(it, sections[0])
+
+	// 物品等级之后第一个没有后缀的词缀段是普通词缀，之后的是传奇的描述文本等
+	explicitDone := false
+	afterItemLevel := false
+	for _, section := range sections[1:] {
+		if parseKnownSection(it, section, &afterItemLevel) {
+			continue
+		}
+		if !afterItemLevel {
+			parseProperties(it, section)
+			continue
+		}
+		hasPlain := false
+		mods := []*Mod{}
+		for _, line := range section {
+			if strings.HasPrefix(line, "{") {
+				// Ctrl+Alt+C复制的词缀说明
+				continue
+			}
+			kind, modText := splitModSuffix(line)
+			if kind == ModExplicit {
+				hasPlain = true
+			}
+			mods = append(mods, NewMod(kind, modText))
+		}
+		if hasPlain && explicitDone {
+			continue
+		}
+		if hasPlain {
+			explicitDone = true
+		}
+		for _, mod := range mods {
+			it.addMod(mod)
+		}
+	}
+	return it, nil
+}
+
+// ParseRarity 解析中文或英文的稀有度，无法识别时返回RarityUnknown
+func ParseRarity(s string) Rarity {
+	if r, ok := rarities[strings.TrimSpace(s)]; ok {
+		return r
+	}
+	return RarityUnknown
+}
+
+func splitSections(text string) [][]string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "：", ":")
+	text = strings.ReplaceAll(text, "（", "(")
+	text = strings.ReplaceAll(text, "）", ")")
+	res := [][]string{}
+	section := []string{}
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == separator {
+			if len(section) > 0 {
+				res = append(res, section)
+			}
+			section = []string{}
+			continue
+		}
+		if line != "" {
+			section = append(section, line)
+		}
+	}
+	if len(section) > 0 {
+		res = append(res, section)
+	}
+	return res
+}
+
+// splitKV 拆分 key: value，key中的空格会被去掉，用于兼容 稀 有 度
+func splitKV(line string) (string, string, bool) {
+	i := strings.Index(line, ":")
+	if i < 0 {
+		return "", "", false
+	}
+	return strings.ReplaceAll(line[:i], " ", ""), strings.TrimSpace(line[i+1:]), true
+}
+
+func keyIs(key string, names []string) bool {
+	for _, name := range names {
+		if key == strings.ReplaceAll(name, " ", "") {
+			return true
+		}
+	}
+	return false
+}
+
+func lineIs(line string, names []string) bool {
+	for _, name := range names {
+		if line == name {
+			return true
+		}
+	}
+	return false
+}
+
+func atoi(v string) int {
+	n, _ := strconv.Atoi(intRe.FindString(v))
+	return n
+}
+
+func parseHeader(it *Item, lines []string) {
+	names := []string{}
+	for _, line := range lines {
+		key, value, ok := splitKV(line)
+		switch {
+		case ok && keyIs(key, keyClass):
+			it.Class = value
+		case ok && keyIs(key, keyRarity):
+			it.Rarity = ParseRarity(value)
+		default:
+			names = append(names, line)
+		}
+	}
+	switch len(names) {
+	case 0:
+	case 1:
+		it.Name = names[0]
+		it.Base = names[0]
+	default:
+		it.Name = names[0]
+		it.Base = names[1]
+	}
+}
+
+// parseKnownSection 处理需求、插槽、物品等级和各种标记，返回false表示不是这些段落
+func parseKnownSection(it *Item, section []string, afterItemLevel *bool) bool {
+	first := section[0]
+	key, value, ok := splitKV(first)
+	switch {
+	case ok && keyIs(key, keyRequirements):
+		parseRequirements(it, section[1:])
+		return true
+	case requiresRe.MatchString(first):
+		for _, part := range strings.Split(requiresRe.FindStringSubmatch(first)[1], ",") {
+			parseRequirePart(it, strings.TrimSpace(part))
+		}
+		return true
+	case ok && keyIs(key, keySockets):
+		it.Sockets = value
+		it.Links = parseSockets(value)
+		return true
+	case ok && keyIs(key, keyItemLevel):
+		it.ItemLevel = atoi(value)
+		*afterItemLevel = true
+		return true
+	case ok && keyIs(key, keyNote):
+		it.Note = value
+		return true
+	}
+
+	// 只包含标记的段落，比如 Corrupted、Shaper Item
+	for _, line := range section {
+		if !isFlag(line) {
+			return false
+		}
+	}
+	for _, line := range section {
+		switch {
+		case lineIs(line, flagCorrupted):
+			it.Corrupted = true
+		case lineIs(line, flagMirrored):
+			it.Mirrored = true
+		case lineIs(line, flagUnidentified):
+			it.Unidentified = true
+		default:
+			it.Influences = append(it.Influences, influences[line])
+		}
+	}
+	return true
+}
+
+func isFlag(line string) bool {
+	if lineIs(line, flagCorrupted) || lineIs(line, flagMirrored) || lineIs(line, flagUnidentified) {
+		return true
+	}
+	_, ok := influences[line]
+	return ok
+}
+
+func parseRequirements(it *Item, lines []string) {
+	for _, line := range lines {
+		key, value, ok := splitKV(line)
+		if !ok {
+			continue
+		}
+		setRequirement(it, key, atoi(value))
+	}
+}
+
+// parseRequirePart 处理 Level 62 或者 180 Str
+func parseRequirePart(it *Item, part string) {
+	fields := strings.Fields(part)
+	if len(fields) != 2 {
+		return
+	}
+	if n, err := strconv.Atoi(fields[1]); err == nil {
+		setRequirement(it, fields[0], n)
+		return
+	}
+	setRequirement(it, fields[1], atoi(fields[0]))
+}
+
+func setRequirement(it *Item, key string, value int) {
+	switch {
+	case keyIs(key, keyLevel):
+		it.Requirements.Level = value
+	case keyIs(key, keyStr):
+		it.Requirements.Str = value
+	case keyIs(key, keyDex):
+		it.Requirements.Dex = value
+	case keyIs(key, keyInt):
+		it.Requirements.Int = value
+	}
+}
+
+// parseProperties 物品等级之前的 key: value 段落，比如品质、护甲、武器伤害
+func parseProperties(it *Item, section []string) {
+	for _, line := range section {
+		key, value, ok := splitKV(line)
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(strings.TrimSuffix(value, "(augmented)"))
+		if keyIs(key, keyQuality) {
+			it.Quality = atoi(value)
+		}
+		it.Properties = append(it.Properties, Property{Name: strings.TrimSpace(line[:strings.Index(line, ":")]), Value: value})
+	}
+}
+
+func splitModSuffix(line string) (ModKind, string) {
+	for suffix, kind := range modSuffixes {
+		if strings.HasSuffix(line, suffix) {
+			return kind, strings.TrimSpace(strings.TrimSuffix(line, suffix))
+		}
+	}
+	return ModExplicit, line
+}
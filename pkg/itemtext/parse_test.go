@@ -0,0 +1,164 @@
+package itemtext
+
+import (
+	"reflect"
+	"testing"
+)
+
+const rareEN = `Item Class: Body Armours
+Rarity: Rare
+Doom Shell
+Astral Plate
+--------
+Quality: +20% (augmented)
+Armour: 1234 (augmented)
+--------
+Requirements:
+Level: 62
+Str: 180
+--------
+Sockets: R-R-G-B B-R 
+--------
+Item Level: 86
+--------
++12% to all Elemental Resistances (implicit)
+--------
++95 to maximum Life
+Adds 10 to 20 Fire Damage (crafted)
+-5% to Chaos Resistance
+15.5% increased Rarity of Items found (fractured)
+--------
+Corrupted
+--------
+Shaper Item
+Elder Item
+--------
+Note: ~price 5 divine
+`
+
+const uniqueCN = "物品类别: 戒指\r\n" +
+	"稀 有 度: 传奇\r\n" +
+	"贪婪之握\r\n" +
+	"紫晶戒指\r\n" +
+	"--------\r\n" +
+	"需求：\r\n" +
+	"等级: 48\r\n" +
+	"--------\r\n" +
+	"物品等级: 84\r\n" +
+	"--------\r\n" +
+	"+17% 混沌抗性 (implicit)\r\n" +
+	"--------\r\n" +
+	"+30 最大生命\r\n" +
+	"--------\r\n" +
+	"贪婪者终将一无所有\r\n" +
+	"--------\r\n" +
+	"已腐化\r\n"
+
+func TestParseEnglish(t *testing.T) {
+	it, err := Parse(rareEN)
+	if err != nil {
+		t.Fatalf("Parse fail, err: %v", err)
+	}
+	if it.Class != "Body Armours" || it.Rarity != RarityRare || it.Name != "Doom Shell" || it.Base != "Astral Plate" {
+		t.Errorf("header = %q %q %q %q", it.Class, it.Rarity, it.Name, it.Base)
+	}
+	if it.ItemLevel != 86 || it.Quality != 20 {
+		t.Errorf("item level = %d, quality = %d", it.ItemLevel, it.Quality)
+	}
+	if it.Sockets != "R-R-G-B B-R" || it.Links != 4 {
+		t.Errorf("sockets = %q, links = %d", it.Sockets, it.Links)
+	}
+	if want := (Requirements{Level: 62, Str: 180}); it.Requirements != want {
+		t.Errorf("requirements = %+v, want %+v", it.Requirements, want)
+	}
+	if len(it.Implicits) != 1 || it.Implicits[0].Template != "+#% to all Elemental Resistances" {
+		t.Errorf("implicits = %+v", it.Implicits)
+	}
+	if len(it.Explicits) != 2 || !reflect.DeepEqual(it.Explicits[1].Values, []float64{-5}) {
+		t.Errorf("explicits = %+v", it.Explicits)
+	}
+	if len(it.Crafted) != 1 || it.Crafted[0].Text != "Adds 10 to 20 Fire Damage" ||
+		!reflect.DeepEqual(it.Crafted[0].Values, []float64{10, 20}) {
+		t.Errorf("crafted = %+v", it.Crafted)
+	}
+	if len(it.Fractured) != 1 || !reflect.DeepEqual(it.Fractured[0].Values, []float64{15.5}) {
+		t.Errorf("fractured = %+v", it.Fractured)
+	}
+	if !it.Corrupted || !reflect.DeepEqual(it.Influences, []string{InfluenceShaper, InfluenceElder}) {
+		t.Errorf("corrupted = %v, influences = %v", it.Corrupted, it.Influences)
+	}
+	if it.Note != "~price 5 divine" {
+		t.Errorf("note = %q", it.Note)
+	}
+	if len(it.Mods()) != 5 {
+		t.Errorf("mods = %d, want 5", len(it.Mods()))
+	}
+	if v := it.ElementalResistance(); v != 36 {
+		t.Errorf("elemental resistance = %v, want 36", v)
+	}
+	if v := it.ChaosResistance(); v != -5 {
+		t.Errorf("chaos resistance = %v, want -5", v)
+	}
+	if v := it.MaximumLife(); v != 95 {
+		t.Errorf("maximum life = %v, want 95", v)
+	}
+}
+
+func TestParseChinese(t *testing.T) {
+	it, err := Parse(uniqueCN)
+	if err != nil {
+		t.Fatalf("Parse fail, err: %v", err)
+	}
+	if it.Rarity != RarityUnique || it.Name != "贪婪之握" || it.Base != "紫晶戒指" {
+		t.Errorf("header = %q %q %q", it.Rarity, it.Name, it.Base)
+	}
+	if it.ItemLevel != 84 || it.Requirements.Level != 48 {
+		t.Errorf("item level = %d, requirements = %+v", it.ItemLevel, it.Requirements)
+	}
+	if len(it.Implicits) != 1 || !reflect.DeepEqual(it.Implicits[0].Values, []float64{17}) {
+		t.Errorf("implicits = %+v", it.Implicits)
+	}
+	// 描述文本不能当作词缀
+	if len(it.Explicits) != 1 || it.Explicits[0].Template != "+# 最大生命" {
+		t.Errorf("explicits = %+v", it.Explicits)
+	}
+	if !it.Corrupted {
+		t.Errorf("corrupted = false")
+	}
+	if v := it.ChaosResistance(); v != 17 {
+		t.Errorf("chaos resistance = %v, want 17", v)
+	}
+}
+
+func TestParseRequiresLine(t *testing.T) {
+	it, err := Parse("Rarity: Normal\nIron Ring\n--------\nRequires Level 20, 15 Dex, 30 Int\n--------\nItem Level: 40\n--------\nUnidentified\n")
+	if err != nil {
+		t.Fatalf("Parse fail, err: %v", err)
+	}
+	if it.Name != "Iron Ring" || it.Base != "Iron Ring" || !it.Unidentified {
+		t.Errorf("item = %+v", it)
+	}
+	if want := (Requirements{Level: 20, Dex: 15, Int: 30}); it.Requirements != want {
+		t.Errorf("requirements = %+v, want %+v", it.Requirements, want)
+	}
+}
+
+func TestParseEmpty(t *testing.T) {
+	if _, err := Parse(" \n--------\n"); err != ErrEmpty {
+		t.Errorf("err = %v, want ErrEmpty", err)
+	}
+}
+
+func TestNewModValues(t *testing.T) {
+	for text, want := range map[string][]float64{
+		"+45(40-50)% to Fire Resistance":                    {45, 40, 50},
+		"Adds 10-20 Fire Damage":                            {10, 20},
+		"-5% to Chaos Resistance":                           {-5},
+		"+-3 to Strength":                                   {-3},
+		"Gain 10% of Physical Damage as Extra Chaos Damage": {10},
+	} {
+		if got := NewMod(ModExplicit, text).Values; !reflect.DeepEqual(got, want) {
+			t.Errorf("NewMod(%q).Values = %v, want %v", text, got, want)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package itemtext
+
+import "strings"
+
+// 抗性词缀的关键字，模板形如 +#% to Fire Resistance、+#% 火焰抗性
+var (
+	resistanceWords = []string{"Resistance", "抗性"}
+	maximumWords    = []string{"maximum", "最大"}
+	allElemental    = []string{"all Elemental Resistances", "所有元素抗性"}
+	elementWords    = [][]string{{"Fire", "火焰"}, {"Cold", "冰霜"}, {"Lightning", "闪电"}}
+	chaosWords      = []string{"Chaos", "混沌"}
+	lifeTemplates   = []string{"+# to maximum Life", "+# 最大生命"}
+)
+
+func containsAny(s string, words []string) bool {
+	for _, w := range words {
+		if strings.Contains(s, w) {
+			return true
+		}
+	}
+	return false
+}
+
+// isResistance 只统计 +#% 和 -#% 开头的抗性，不包括最大抗性
+func isResistance(mod *Mod) bool {
+	return (strings.HasPrefix(mod.Template, "+#%") || strings.HasPrefix(mod.Template, "-#%")) && len(mod.Values) > 0 &&
+		containsAny(mod.Template, resistanceWords) && !containsAny(mod.Template, maximumWords)
+}
+
+// ElementalResistance 火焰、冰霜、闪电抗性之和，全抗计三次，双抗计两次
+func (it *Item) ElementalResistance() float64 {
+	total := 0.0
+	for _, mod := range it.Mods() {
+		if !isResistance(mod) {
+			continue
+		}
+		if containsAny(mod.Template, allElemental) {
+			total += mod.Values[0] * 3
+			continue
+		}
+		for _, words := range elementWords {
+			if containsAny(mod.Template, words) {
+				total += mod.Values[0]
+			}
+		}
+	}
+	return total
+}
+
+// ChaosResistance 混沌抗性之和
+func (it *Item) ChaosResistance() float64 {
+	total := 0.0
+	for _, mod := range it.Mods() {
+		if isResistance(mod) && containsAny(mod.Template, chaosWords) {
+			total += mod.Values[0]
+		}
+	}
+	return total
+}
+
+// MaximumLife 最大生命之和
+func (it *Item) MaximumLife() float64 {
+	total := 0.0
+	for _, mod := range it.Mods() {
+		for _, t := range lifeTemplates {
+			if mod.Template == t && len(mod.Values) > 0 {
+				total += mod.Values[0]
+			}
+		}
+	}
+	return total
+}
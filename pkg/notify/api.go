@@ -4,4 +4,23 @@ import "context"
 
 type Client interface {
 	SendTextMsg(ctx context.Context, msg string) error
+	SendMarkdownMsg(ctx context.Context, msg string) error
+}
+
+type Format string
+
+const (
+	FormatText     Format = "text"
+	FormatMarkdown Format = "markdown"
+)
+
+// Message 同一条通知的纯文本和markdown版本，Markdown为空时发送纯文本
+type Message struct {
+	Text     string `json:"text"`
+	Markdown string `json:"markdown,omitempty"`
+}
+
+// Notifier 按目标配置的格式发送消息
+type Notifier interface {
+	Send(ctx context.Context, msg *Message) error
 }
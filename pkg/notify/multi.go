@@ -0,0 +1,68 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+const TypeWxWork = "wxwork"
+
+// New 按类型生成客户端，typ为空时为企业微信
+func New(typ string, reqURI string) (Client, error) {
+	switch typ {
+	case "", TypeWxWork:
+		return NewWxWork(reqURI), nil
+	}
+	return nil, fmt.Errorf("unknown notify type %s", typ)
+}
+
+// ParseFormat 为空时为纯文本
+func ParseFormat(format string) (Format, error) {
+	switch Format(format) {
+	case "", FormatText:
+		return FormatText, nil
+	case FormatMarkdown:
+		return FormatMarkdown, nil
+	}
+	return "", fmt.Errorf("unknown notify format %s", format)
+}
+
+type target struct {
+	client Client
+	format Format
+}
+
+// NewTarget 按format选择消息的版本发送
+func NewTarget(c Client, format Format) Notifier {
+	return &target{client: c, format: format}
+}
+
+func (t *target) Send(ctx context.Context, msg *Message) error {
+	if t.format == FormatMarkdown && msg.Markdown != "" {
+		return t.client.SendMarkdownMsg(ctx, msg.Markdown)
+	}
+	return t.client.SendTextMsg(ctx, msg.Text)
+}
+
+type multiNotifier struct {
+	notifiers []Notifier
+}
+
+// NewMulti 依次发送到所有目标，某个失败不影响其他目标
+func NewMulti(notifiers ...Notifier) Notifier {
+	if len(notifiers) == 1 {
+		return notifiers[0]
+	}
+	return &multiNotifier{notifiers: notifiers}
+}
+
+func (m *multiNotifier) Send(ctx context.Context, msg *Message) error {
+	errs := []error{}
+	for _, n := range m.notifiers {
+		if err := n.Send(ctx, msg); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 
@@ -17,7 +18,8 @@ type wxWorkClient struct {
 type wxMsgType string
 
 const (
-	wxMsgTypeText wxMsgType = "text"
+	wxMsgTypeText     wxMsgType = "text"
+	wxMsgTypeMarkdown wxMsgType = "markdown"
 )
 
 type wxWorkTextMsg struct {
@@ -25,8 +27,9 @@ type wxWorkTextMsg struct {
 }
 
 type wxWorkMsg struct {
-	MsgType wxMsgType      `json:"msgtype"`
-	Text    *wxWorkTextMsg `json:"text,omitempty"`
+	MsgType  wxMsgType      `json:"msgtype"`
+	Text     *wxWorkTextMsg `json:"text,omitempty"`
+	Markdown *wxWorkTextMsg `json:"markdown,omitempty"`
 }
 
 func NewWxWork(reqURI string) Client {
@@ -49,6 +52,23 @@ func (c *wxWorkClient) SendTextMsg(ctx context.Context, msg string) error {
 	return nil
 }
 
+func (c *wxWorkClient) SendMarkdownMsg(ctx context.Context, msg string) error {
+	body, err := json.Marshal(&wxWorkMsg{
+		MsgType:  wxMsgTypeMarkdown,
+		Markdown: &wxWorkTextMsg{Content: msg},
+	})
+	if err != nil {
+		log.WithContext(ctx).Errorf("Marshal fail, err: %v", err)
+		return err
+	}
+	err = c.invoke(ctx, body)
+	if err != nil {
+		log.WithContext(ctx).Errorf("Invoke fail, err: %v", err)
+		return err
+	}
+	return nil
+}
+
 func (c *wxWorkClient) packTextMsg(ctx context.Context, msg string) ([]byte, error) {
 	sMsg := &wxWorkMsg{
 		MsgType: wxMsgTypeText,
@@ -76,11 +96,25 @@ func (c *wxWorkClient) invoke(ctx context.Context, body []byte) error {
 		log.WithContext(ctx).Debugf("Do WxWork fail, err: %v", err)
 		return err
 	}
+	defer rsp.Body.Close()
 	rspBody, err := io.ReadAll(rsp.Body)
 	if err != nil {
 		log.WithContext(ctx).Debugf("Read WxWork rsp fail, err: %v", err)
 		return err
 	}
 	log.WithContext(ctx).Debugf("rspBody: %s", string(rspBody))
+	if rsp.StatusCode < 200 || rsp.StatusCode >= 300 {
+		return fmt.Errorf("wxwork status %d: %s", rsp.StatusCode, rspBody)
+	}
+	// 限频等错误也返回200，需要检查errcode
+	wxRsp := &wxWorkRsp{}
+	if err := json.Unmarshal(rspBody, wxRsp); err == nil && wxRsp.ErrCode != 0 {
+		return fmt.Errorf("wxwork errcode %d: %s", wxRsp.ErrCode, wxRsp.ErrMsg)
+	}
 	return nil
 }
+
+type wxWorkRsp struct {
+	ErrCode int    `json:"errcode"`
+	ErrMsg  string `json:"errmsg"`
+}